@@ -26,6 +26,13 @@ function StartStop({ session, user }: StartStopProps): React.JSX.Element {
         })
     }
 
+    const reopenVote = async (): Promise<void> => {
+        await axios.put('/api/vote/reopen', {
+            session_id: session.id,
+            user_id: user.id,
+        })
+    }
+
     if (session.status == SessionState.VOTING) {
         return (
             <div id="startStop">
@@ -42,6 +49,11 @@ function StartStop({ session, user }: StartStopProps): React.JSX.Element {
                 <button className="btn start" onClick={startVote}>
                     <i className="fas fa-play"></i>Start the vote
                 </button>
+                {session.tally && (
+                    <button className="btn reopen" onClick={reopenVote}>
+                        <i className="fas fa-undo"></i>Reopen the vote
+                    </button>
+                )}
             </div>
         )
     }
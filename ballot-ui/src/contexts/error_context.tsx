@@ -8,7 +8,7 @@
 // eslint-disable-next-line import/named
 import axios, { AxiosError, AxiosResponse } from 'axios'
 import React, { createContext, Dispatch, ReactNode, SetStateAction, useContext, useMemo, useState } from 'react'
-import { TError, TValidationError } from '../types/types.tsx'
+import { TError, TApiError } from '../types/types.tsx'
 
 interface ErrorContextType {
     generalError: TError
@@ -46,13 +46,18 @@ const ErrorContextProvider = ({ children }: { children: ReactNode }): React.JSX.
     }
 
     const onResponseError = (axiosError: AxiosError): Promise<AxiosError> => {
-        switch (axiosError.response?.status) {
-            case 400:
-                const validationError = axiosError as AxiosError<TValidationError>
-                setFormError(validationError.response?.data.error || axiosError.response?.statusText)
-                break
-            default:
-                setGeneralError(axiosError.response?.statusText || 'Error: HTTP' + axiosError.response?.status)
+        const apiError = (axiosError as AxiosError<TApiError>).response?.data
+        if (apiError?.errors?.length) {
+            // the rows of an uploaded backlog that were wrong
+            const rowErrors = apiError.errors.map((e: TApiError) => `row ${e.row}, ${e.field}: ${e.message}`)
+            setGeneralError(`${apiError.message} - ${rowErrors.join('; ')}`)
+        } else if (apiError?.field) {
+            // the form field that was wrong
+            setFormError(apiError.message)
+        } else {
+            setGeneralError(
+                apiError?.message || axiosError.response?.statusText || 'Error: HTTP' + axiosError.response?.status
+            )
         }
         return Promise.reject(axiosError)
     }
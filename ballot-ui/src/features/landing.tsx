@@ -12,13 +12,19 @@ function Landing(): React.JSX.Element {
     let sessionId: string | null = null
     let userId: string | null = null
     const [name, setName] = useState<string | null>()
+    const [backlog, setBacklog] = useState<File | null>(null)
     const { generalError, formError } = useErrorContext()
 
     async function createNewSession(event: React.FormEvent<HTMLFormElement>): Promise<void> {
         event.preventDefault()
 
         try {
-            const createSessionResponse = await axios.post('/api/session')
+            // an optional story backlog goes up as it is, the server tells CSV from JSON by the content type
+            const createSessionResponse = backlog
+                ? await axios.post('/api/session', backlog, {
+                      headers: { 'Content-Type': backlog.name.endsWith('.csv') ? 'text/csv' : 'application/json' },
+                  })
+                : await axios.post('/api/session')
             sessionId = createSessionResponse.data.id
             console.assert(sessionId, 'sessionId is required')
         } catch {
@@ -59,6 +65,14 @@ function Landing(): React.JSX.Element {
                         placeholder="Your name/alias"
                         onChange={(e) => setName(e.target.value)}
                     />
+                    <label id="backlog">
+                        Story backlog (optional, CSV or JSON){' '}
+                        <input
+                            type="file"
+                            accept=".csv,.json,text/csv,application/json"
+                            onChange={(e) => setBacklog(e.target.files?.[0] ?? null)}
+                        />
+                    </label>
                     <button type="submit" className="success">
                         New Voting Space
                     </button>
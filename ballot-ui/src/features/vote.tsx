@@ -46,6 +46,19 @@ function Vote(): React.JSX.Element {
         })
     }
 
+    const acceptEstimate = async (estimate: string): Promise<void> => {
+        try {
+            await axios.put('/api/vote/accept', {
+                session_id: sessionId,
+                user_id: userId,
+                estimate: estimate,
+            })
+        } catch {
+            // the error interceptor shows what went wrong
+            return
+        }
+    }
+
     const votersJsx = voters.map((voter: User) => {
         return <Voter voter={voter} session={session} key={voter.id} />
     })
@@ -95,7 +108,56 @@ function Vote(): React.JSX.Element {
     const tallyJsx: React.JSX.Element =
         session.status == SessionState.IDLE && session.tally ? (
             <div id="tally">
-                <span>Estimate: {session.tally}</span>
+                <span>
+                    Estimate: {session.estimate || session.tally}
+                    {session.estimate && session.estimate !== session.tally ? (
+                        <span className="computed">tally was {session.tally}</span>
+                    ) : (
+                        <></>
+                    )}
+                </span>
+            </div>
+        ) : (
+            <></>
+        )
+
+    // the team may settle on a number other than the tally, such as one end of a range
+    const acceptJsx: React.JSX.Element =
+        user.is_admin && session.status == SessionState.IDLE && session.tally ? (
+            <div id="accept">
+                <span>Accept an estimate:</span>
+                {cardValues
+                    .filter((estimate: string) => estimate !== '?')
+                    .map((estimate: string) => (
+                        <button
+                            key={estimate}
+                            className={'btn ' + (session.estimate === estimate ? 'selected' : '')}
+                            onClick={() => acceptEstimate(estimate)}
+                        >
+                            {estimate}
+                        </button>
+                    ))}
+            </div>
+        ) : (
+            <></>
+        )
+
+    const exportUrl = (format: string): string => `/api/session/${session.id}/export?format=${format}`
+    const exportJsx: React.JSX.Element =
+        user.is_admin && session.status == SessionState.IDLE && session.tally ? (
+            <div id="export">
+                Export this session as{' '}
+                <a href={exportUrl('md')} download>
+                    Markdown
+                </a>
+                ,{' '}
+                <a href={exportUrl('csv')} download>
+                    CSV
+                </a>{' '}
+                or{' '}
+                <a href={exportUrl('json')} download>
+                    JSON
+                </a>
             </div>
         ) : (
             <></>
@@ -143,6 +205,8 @@ function Vote(): React.JSX.Element {
                 {startMessageJsx}
                 {cardsJsx}
                 {tallyJsx}
+                {acceptJsx}
+                {exportJsx}
                 <div id="voters">{votersJsx}</div>
             </div>
             <Footer />
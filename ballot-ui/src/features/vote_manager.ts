@@ -16,6 +16,11 @@ const enum WebsocketAction {
     USER_VOTED = 'USER_VOTED',
     VOTE_FINISHED = 'VOTE_FINISHED',
     OBSERVER_LEFT = 'OBSERVER_LEFT',
+    SERVER_RESTARTING = 'SERVER_RESTARTING',
+    SESSION_CLOSED = 'SESSION_CLOSED',
+    SESSION_EXPIRING = 'SESSION_EXPIRING',
+    SESSION_EXPIRED = 'SESSION_EXPIRED',
+    ESTIMATE_ACCEPTED = 'ESTIMATE_ACCEPTED',
 }
 
 export function useVoteManager({ userId, sessionId }: { userId: string | undefined; sessionId: string | undefined }): {
@@ -40,6 +45,7 @@ export function useVoteManager({ userId, sessionId }: { userId: string | undefin
         id: sessionId,
         status: SessionState.IDLE,
         tally: NO_ESTIMATE,
+        estimate: NO_ESTIMATE,
         users: [],
         observers: [],
     })
@@ -61,18 +67,22 @@ export function useVoteManager({ userId, sessionId }: { userId: string | undefin
         console.debug('User ID:', userId)
 
         const ws: Websockets = new Websockets()
+        // a closed session is gone for good, there is nothing to reconnect to
+        let sessionClosed = false
 
         /**
          * A mobile device may have lost connection on sleep or locked screen.
          * Calling "reconnect" should be harmless, and not always effective.
          */
         document.addEventListener('visibilitychange', () => {
-            if (!document.hidden) {
+            if (!document.hidden && !sessionClosed) {
                 ws.reconnect()
             }
         })
         window.addEventListener('blur', () => {
-            ws.reconnect()
+            if (!sessionClosed) {
+                ws.reconnect()
+            }
         })
 
         const fetchUser = async (): Promise<void> => {
@@ -144,11 +154,28 @@ export function useVoteManager({ userId, sessionId }: { userId: string | undefin
             })
         }
 
-        function votingStartedWsHandler(): void {
+        function votingStartedWsHandler(json: { reopened?: boolean; users?: User[] }): void {
             setSession((draft) => {
                 draft.status = SessionState.VOTING
+                draft.estimate = NO_ESTIMATE
+                if (json.reopened) {
+                    draft.tally = ''
+                }
             })
 
+            if (json.reopened) {
+                // the votes cast stand, so keep our own estimate if we voted
+                const voters: User[] = json.users ?? []
+                setVoters(voters)
+                setUser((draft) => {
+                    draft.voted = voters.some((voter) => voter.id === draft.id && voter.voted)
+                    if (!draft.voted) {
+                        draft.estimate = NO_ESTIMATE
+                    }
+                })
+                return
+            }
+
             setVoters((draft) => {
                 draft.forEach((voter) => {
                     voter.voted = false
@@ -166,6 +193,7 @@ export function useVoteManager({ userId, sessionId }: { userId: string | undefin
             setSession((draft) => {
                 draft.status = ses.status
                 draft.tally = ses.tally
+                draft.estimate = ses.estimate
             })
 
             setVoters(ses.users)
@@ -176,6 +204,7 @@ export function useVoteManager({ userId, sessionId }: { userId: string | undefin
             setSession((draft) => {
                 draft.status = SessionState.IDLE
                 draft.tally = ses.tally
+                draft.estimate = NO_ESTIMATE
             })
 
             setVoters(ses.users)
@@ -202,7 +231,7 @@ export function useVoteManager({ userId, sessionId }: { userId: string | undefin
                     break
                 }
                 case WebsocketAction.VOTING: {
-                    votingStartedWsHandler()
+                    votingStartedWsHandler(json)
                     break
                 }
                 case WebsocketAction.USER_VOTED: {
@@ -213,6 +242,12 @@ export function useVoteManager({ userId, sessionId }: { userId: string | undefin
                     votingFinishedWsHandler(json as Session)
                     break
                 }
+                case WebsocketAction.ESTIMATE_ACCEPTED: {
+                    setSession((draft) => {
+                        draft.estimate = json['estimate']
+                    })
+                    break
+                }
                 case WebsocketAction.USER_LEFT: {
                     userLeftWsHandler(json['user_id'])
                     break
@@ -221,6 +256,29 @@ export function useVoteManager({ userId, sessionId }: { userId: string | undefin
                     observerLeftWsHandler(json['user_id'])
                     break
                 }
+                case WebsocketAction.SERVER_RESTARTING: {
+                    // the socket reconnects on its own, and WATCHING clears this
+                    setGeneralError(json['message'])
+                    break
+                }
+                case WebsocketAction.SESSION_CLOSED: {
+                    sessionClosed = true
+                    ws.close()
+                    setGeneralError('This session was closed by the facilitator')
+                    break
+                }
+                case WebsocketAction.SESSION_EXPIRING: {
+                    // any activity in the session extends it, and the next event clears this
+                    const expiresAt = new Date(json['expires_at']).toLocaleTimeString()
+                    setGeneralError(`This session has been idle, and expires at ${expiresAt}`)
+                    break
+                }
+                case WebsocketAction.SESSION_EXPIRED: {
+                    sessionClosed = true
+                    ws.close()
+                    setGeneralError('This session expired after being idle for too long')
+                    break
+                }
             }
         })
 
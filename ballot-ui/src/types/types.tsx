@@ -3,6 +3,8 @@ import { SessionState } from '../constants.ts'
 export interface Session {
     id: string | undefined
     tally: string
+    // accepted by a facilitator, in place of the tally
+    estimate: string
     status: SessionState
     users: User[]
     observers: User[]
@@ -19,6 +21,10 @@ export interface User {
 
 export type TError = string | null
 
-export type TValidationError = {
-    error: string
+export type TApiError = {
+    code: string
+    message: string
+    field?: string
+    row?: number
+    errors?: TApiError[]
 }
@@ -74,7 +74,10 @@ describe('Landing page tests', () => {
                 })
             }),
             http.post('/api/user', () => {
-                return HttpResponse.json({ error: formErrorText }, { status: 400 })
+                return HttpResponse.json(
+                    { code: 'validation', message: formErrorText, field: 'user.name' },
+                    { status: 400 }
+                )
             }),
         ]
 
@@ -0,0 +1,239 @@
+package backlog
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/papito/ballot/ballot/errors"
+	"github.com/papito/ballot/ballot/model"
+	"io"
+	"mime"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+/* A backlog is uploaded as CSV, with a header row naming the title, key and link columns, or as a JSON array
+of stories. Issue tracker exports name things their own way, so "summary" is taken for the title, "number"
+for the key, and "url" or "html_url" for the link. Anything else in the file is ignored.
+
+Every row is checked before any of it is queued. The rows are counted from 1 - in a CSV file the header
+is row 1, so the row numbers match the ones a spreadsheet shows.
+*/
+
+const (
+	MaxStories     = 500
+	MaxTitleLength = 200
+	MaxKeyLength   = 64
+
+	// the most row errors reported, so that a file in the wrong format does not get a response as big as itself
+	maxRowErrors = 50
+)
+
+// Parse reads the stories in a CSV or JSON backlog. A file that cannot be read is an invalid request,
+// and a file with bad rows is a validation error with the errors of each row.
+func Parse(contentType string, data []byte) ([]model.Story, error) {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+
+	var stories []model.Story
+	var rows []int
+	var err error
+	switch mediaType {
+	case "text/csv":
+		stories, rows, err = parseCsv(data)
+	case "application/json", "":
+		stories, rows, err = parseJson(data)
+	default:
+		return nil, errors.Error{Code: errors.InvalidRequest, Message: "A backlog must be text/csv or application/json"}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(stories) == 0 {
+		return nil, errors.Error{Code: errors.Validation, Message: "The backlog has no stories"}
+	}
+	if len(stories) > MaxStories {
+		return nil, errors.Error{Code: errors.Validation,
+			Message: fmt.Sprintf("A backlog can have at most %d stories", MaxStories)}
+	}
+
+	var rowErrors []errors.Error
+	keys := make(map[string]int)
+	for idx := range stories {
+		story := &stories[idx]
+		row := rows[idx]
+		story.Key = strings.TrimSpace(story.Key)
+		story.Title = strings.TrimSpace(story.Title)
+		story.Link = strings.TrimSpace(story.Link)
+
+		rowErrors = append(rowErrors, validate(*story, row)...)
+
+		if story.Key != "" {
+			if first, ok := keys[story.Key]; ok {
+				rowErrors = append(rowErrors, rowError(row, "key", fmt.Sprintf("The key is already in row %d", first)))
+			} else {
+				keys[story.Key] = row
+			}
+		}
+	}
+
+	if len(rowErrors) > 0 {
+		message := fmt.Sprintf("The backlog has %d errors", len(rowErrors))
+		if len(rowErrors) > maxRowErrors {
+			rowErrors = rowErrors[:maxRowErrors]
+			message += fmt.Sprintf(", the first %d of them are listed", maxRowErrors)
+		}
+		return nil, errors.Error{Code: errors.Validation, Message: message, Errors: rowErrors}
+	}
+	return stories, nil
+}
+
+func rowError(row int, field string, message string) errors.Error {
+	return errors.Error{Code: errors.Validation, Row: row, Field: field, Message: message}
+}
+
+func validate(story model.Story, row int) []errors.Error {
+	var rowErrors []errors.Error
+
+	switch {
+	case story.Title == "":
+		rowErrors = append(rowErrors, rowError(row, "title", "This field cannot be empty"))
+	case len([]rune(story.Title)) > MaxTitleLength:
+		rowErrors = append(rowErrors, rowError(row, "title",
+			fmt.Sprintf("This field can be at most %d characters", MaxTitleLength)))
+	}
+
+	if len([]rune(story.Key)) > MaxKeyLength {
+		rowErrors = append(rowErrors, rowError(row, "key",
+			fmt.Sprintf("This field can be at most %d characters", MaxKeyLength)))
+	}
+
+	if story.Link != "" {
+		link, err := url.Parse(story.Link)
+		if err != nil || (link.Scheme != "http" && link.Scheme != "https") || link.Host == "" {
+			rowErrors = append(rowErrors, rowError(row, "link", "This field must be an http or https URL"))
+		}
+	}
+	return rowErrors
+}
+
+// the names a field goes by, the one taken first when a file has more than one of them
+var names = map[string][]string{
+	"title": {"title", "summary"},
+	"key":   {"key", "number"},
+	"link":  {"link", "url", "html_url"},
+}
+
+// lookup finds a field by the first of its names that is there
+func lookup[T any](fields map[string]T, field string) (T, bool) {
+	for _, name := range names[field] {
+		val, ok := fields[name]
+		if ok {
+			return val, true
+		}
+	}
+	var none T
+	return none, false
+}
+
+func parseCsv(data []byte) ([]model.Story, []int, error) {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, nil, errors.Error{Code: errors.InvalidRequest, Message: "The backlog has no header row"}
+	}
+
+	headerColumns := make(map[string]int)
+	for idx, name := range header {
+		// spreadsheets tend to start a UTF-8 file with a byte order mark
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		if _, ok := headerColumns[name]; !ok {
+			headerColumns[name] = idx
+		}
+	}
+	if _, ok := lookup(headerColumns, "title"); !ok {
+		return nil, nil, errors.Error{Code: errors.Validation, Message: "The backlog has errors",
+			Errors: []errors.Error{rowError(1, "title", "The header has no title column")}}
+	}
+
+	cell := func(record []string, field string) string {
+		idx, ok := lookup(headerColumns, field)
+		if !ok || idx >= len(record) {
+			return ""
+		}
+		return record[idx]
+	}
+
+	var stories []model.Story
+	var rows []int
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			line := 0
+			if parseErr, ok := err.(*csv.ParseError); ok {
+				line = parseErr.Line
+			}
+			return nil, nil, errors.Error{Code: errors.InvalidRequest, Row: line, Message: "The backlog is not valid CSV"}
+		}
+
+		line, _ := reader.FieldPos(0)
+		stories = append(stories, model.Story{
+			Key:   cell(record, "key"),
+			Title: cell(record, "title"),
+			Link:  cell(record, "link"),
+		})
+		rows = append(rows, line)
+	}
+	return stories, rows, nil
+}
+
+func parseJson(data []byte) ([]model.Story, []int, error) {
+	var items []json.RawMessage
+	err := json.Unmarshal(data, &items)
+	if err != nil {
+		return nil, nil, errors.Error{Code: errors.InvalidRequest, Message: "The backlog must be a JSON array of stories"}
+	}
+
+	var stories []model.Story
+	var rows []int
+	for idx, item := range items {
+		var object map[string]interface{}
+		err = json.Unmarshal(item, &object)
+		if err != nil || object == nil {
+			return nil, nil, errors.Error{Code: errors.InvalidRequest, Row: idx + 1, Message: "A story must be a JSON object"}
+		}
+
+		fields := make(map[string]interface{}, len(object))
+		for name, val := range object {
+			fields[strings.ToLower(name)] = val
+		}
+		field := func(field string) string {
+			val, _ := lookup(fields, field)
+			return jsonString(val)
+		}
+
+		stories = append(stories, model.Story{Key: field("key"), Title: field("title"), Link: field("link")})
+		rows = append(rows, idx+1)
+	}
+	return stories, rows, nil
+}
+
+// jsonString is a string or a number, such as an issue number, as text
+func jsonString(val interface{}) string {
+	switch v := val.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return ""
+	}
+}
@@ -1,17 +1,101 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
 	"github.com/papito/ballot/ballot/config"
+	"github.com/papito/ballot/ballot/logutil"
 	"github.com/papito/ballot/ballot/server"
 	"log"
+	"log/slog"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
+// How long in-flight requests get to finish on shutdown
+const shutdownTimeout = 15 * time.Second
+
 func main() {
-	envConfig := config.LoadConfig()
+	envConfig, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatalf("Invalid configuration:\n%v", err)
+	}
+
+	if envConfig.PrintConfig {
+		err = envConfig.Print(os.Stdout)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	slog.SetDefault(logutil.NewLogger(
+		os.Stderr, envConfig.SlogLevel(), envConfig.LogFormat, envConfig.LogUserNames))
+	slog.Info("Configuration loaded",
+		"env", envConfig.Environment, "host", envConfig.HttpHost, "broker", envConfig.Broker)
+
+	if len(envConfig.Command) > 0 {
+		err = runCommand(envConfig)
+		if err != nil && !errors.Is(err, flag.ErrHelp) {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	srv := server.NewServer(envConfig)
-	defer srv.Release()
 
-	log.Printf("Starting server on port %s", envConfig.HttpPort)
-	log.Fatal(http.ListenAndServe(envConfig.HttpPort, nil))
+	httpServer := &http.Server{
+		Addr:              envConfig.HttpPort,
+		Handler:           srv,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go func() {
+		slog.Info("Starting server", "port", envConfig.HttpPort)
+		err := httpServer.ListenAndServe()
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
+
+	<-ctx.Done()
+	stop()
+	slog.Info("Shutting down")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	// Stops accepting connections and waits for requests to finish. Sockets are hijacked connections
+	// the HTTP server no longer tracks, so they are drained by releasing the hub.
+	err = httpServer.Shutdown(shutdownCtx)
+	if err != nil {
+		logutil.Error(context.Background(), err)
+	}
+
+	srv.Release()
+}
+
+// runCommand runs a command given after the settings, such as "ballot --redis-url ... gc"
+func runCommand(conf config.Config) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	name, args := conf.Command[0], conf.Command[1:]
+	switch name {
+	case "gc":
+		return gc(ctx, conf, args, os.Stdout)
+	default:
+		return fmt.Errorf("unknown command %q", name)
+	}
 }
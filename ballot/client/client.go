@@ -0,0 +1,267 @@
+// Package client is a Go client for the Ballot REST API and session event stream.
+//
+//	c := client.New("http://localhost:8080")
+//	session, err := c.CreateSession(ctx)
+//	...
+//	sub := c.Subscribe(ctx, session.SessionId, user.UserId)
+//	for event := range sub.Events() {
+//		...
+//	}
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/joomcode/errorx"
+	"github.com/papito/ballot/ballot/errors"
+	"github.com/papito/ballot/ballot/model"
+	"github.com/papito/ballot/ballot/model/request"
+	"github.com/papito/ballot/ballot/model/response"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type Client struct {
+	BaseUrl    string
+	HttpClient *http.Client
+
+	// Subscriptions reconnect with exponential backoff between these two delays
+	ReconnectDelay    time.Duration
+	MaxReconnectDelay time.Duration
+}
+
+// Error is returned for any response that is not a 200. Code, Message and Field are set when the body is an API error,
+// and Errors when the error is about several rows of a backlog.
+type Error struct {
+	StatusCode int
+	Method     string
+	Path       string
+	Body       string
+
+	Code    errors.Code
+	Message string
+	Field   string
+	Errors  []errors.Error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s %s: %d %s: %s", e.Method, e.Path, e.StatusCode, http.StatusText(e.StatusCode), e.Body)
+}
+
+func New(baseUrl string) *Client {
+	return &Client{
+		BaseUrl:           strings.TrimRight(baseUrl, "/"),
+		HttpClient:        &http.Client{Timeout: 30 * time.Second},
+		ReconnectDelay:    500 * time.Millisecond,
+		MaxReconnectDelay: 30 * time.Second,
+	}
+}
+
+func (p *Client) Health(ctx context.Context) (response.HealthResponse, error) {
+	var health response.HealthResponse
+	err := p.do(ctx, "GET", "/health", nil, &health)
+	return health, err
+}
+
+// CreateSession creates a session, with the stories, if any, queued in its backlog
+func (p *Client) CreateSession(ctx context.Context, stories ...model.Story) (model.Session, error) {
+	var reqObj interface{}
+	if len(stories) > 0 {
+		reqObj = stories
+	}
+
+	var session model.Session
+	err := p.do(ctx, "POST", "/api/session", reqObj, &session)
+	return session, err
+}
+
+func (p *Client) GetSession(ctx context.Context, sessionId string) (model.SessionSnapshot, error) {
+	var session model.SessionSnapshot
+	err := p.do(ctx, "GET", "/api/session/"+url.PathEscape(sessionId), nil, &session)
+	return session, err
+}
+
+// ExportSession is the record of the session and its rounds, as a json, csv or md file. The format defaults to json.
+func (p *Client) ExportSession(ctx context.Context, sessionId string, format string) ([]byte, error) {
+	path := "/api/session/" + url.PathEscape(sessionId) + "/export"
+	if format != "" {
+		path += "?format=" + url.QueryEscape(format)
+	}
+	return p.send(ctx, "GET", path, nil)
+}
+
+// CloseSession ends the session for everyone and deletes it. The user must be a facilitator of the session.
+func (p *Client) CloseSession(ctx context.Context, sessionId string, userId string) error {
+	path := fmt.Sprintf("/api/session/%s?user_id=%s", url.PathEscape(sessionId), url.QueryEscape(userId))
+	return p.do(ctx, "DELETE", path, nil, nil)
+}
+
+// QueueStories adds the stories to the end of the session backlog, and answers with the whole backlog
+func (p *Client) QueueStories(ctx context.Context, sessionId string, stories []model.Story) ([]model.Story, error) {
+	var backlog response.BacklogResponse
+	err := p.do(ctx, "POST", "/api/session/"+url.PathEscape(sessionId)+"/backlog", stories, &backlog)
+	return backlog.Stories, err
+}
+
+func (p *Client) GetBacklog(ctx context.Context, sessionId string) ([]model.Story, error) {
+	var backlog response.BacklogResponse
+	err := p.do(ctx, "GET", "/api/session/"+url.PathEscape(sessionId)+"/backlog", nil, &backlog)
+	return backlog.Stories, err
+}
+
+// SetTracker connects the session to an issue tracker. The user must be a facilitator of the session.
+func (p *Client) SetTracker(ctx context.Context, sessionId string, userId string, settings model.Tracker) (model.Tracker, error) {
+	reqObj := request.SetTrackerRequest{
+		UserId: userId,
+		Kind:   settings.Kind,
+		Repo:   settings.Repo,
+		Token:  settings.Token,
+		Label:  settings.Label,
+	}
+
+	var connected model.Tracker
+	err := p.do(ctx, "PUT", "/api/session/"+url.PathEscape(sessionId)+"/tracker", reqObj, &connected)
+	return connected, err
+}
+
+// ImportIssues queues the open issues of the session's issue tracker that match the query, and answers
+// with the whole backlog. The user must be a facilitator of the session.
+func (p *Client) ImportIssues(ctx context.Context, sessionId string, userId string, query string) ([]model.Story, error) {
+	var backlog response.BacklogResponse
+	path := "/api/session/" + url.PathEscape(sessionId) + "/tracker/import"
+	err := p.do(ctx, "POST", path, request.ImportIssuesRequest{UserId: userId, Query: query}, &backlog)
+	return backlog.Stories, err
+}
+
+func (p *Client) CreateUser(ctx context.Context, sessionId string, name string, isAdmin bool, isObserver bool) (model.User, error) {
+	reqObj := request.CreateUserRequest{
+		UserName:   name,
+		SessionId:  sessionId,
+		IsAdmin:    boolToInt(isAdmin),
+		IsObserver: boolToInt(isObserver),
+	}
+
+	var user model.User
+	err := p.do(ctx, "POST", "/api/user", reqObj, &user)
+	return user, err
+}
+
+func (p *Client) GetUser(ctx context.Context, userId string) (model.User, error) {
+	var user model.User
+	err := p.do(ctx, "GET", "/api/user/"+url.PathEscape(userId), nil, &user)
+	return user, err
+}
+
+func (p *Client) StartVote(ctx context.Context, sessionId string) error {
+	return p.do(ctx, "PUT", "/api/vote/start", request.StartVoteRequest{SessionId: sessionId}, nil)
+}
+
+func (p *Client) FinishVote(ctx context.Context, sessionId string) error {
+	return p.do(ctx, "PUT", "/api/vote/finish", request.FinishVoteRequest{SessionId: sessionId}, nil)
+}
+
+// ReopenVote puts a finished vote back into voting, keeping the votes cast. The user must be a facilitator
+// of the session.
+func (p *Client) ReopenVote(ctx context.Context, sessionId string, userId string) error {
+	reqObj := request.ReopenVoteRequest{SessionId: sessionId, UserId: userId}
+	return p.do(ctx, "PUT", "/api/vote/reopen", reqObj, nil)
+}
+
+func (p *Client) CastVote(ctx context.Context, sessionId string, userId string, estimate string) (model.PendingVote, error) {
+	reqObj := request.CastVoteRequest{
+		SessionId: sessionId,
+		UserId:    userId,
+		Estimate:  estimate,
+	}
+
+	var vote model.PendingVote
+	err := p.do(ctx, "PUT", "/api/vote/cast", reqObj, &vote)
+	return vote, err
+}
+
+// AcceptEstimate settles the estimate of the last round. The user must be a facilitator of the session.
+func (p *Client) AcceptEstimate(ctx context.Context, sessionId string, userId string, estimate string) (model.Round, error) {
+	reqObj := request.AcceptEstimateRequest{
+		SessionId: sessionId,
+		UserId:    userId,
+		Estimate:  estimate,
+	}
+
+	var round model.Round
+	err := p.do(ctx, "PUT", "/api/vote/accept", reqObj, &round)
+	return round, err
+}
+
+func (p *Client) do(ctx context.Context, method string, path string, reqObj interface{}, respObj interface{}) error {
+	data, err := p.send(ctx, method, path, reqObj)
+	if err != nil {
+		return err
+	}
+	if respObj == nil {
+		return nil
+	}
+
+	err = json.Unmarshal(data, respObj)
+	if err != nil {
+		return errorx.EnsureStackTrace(err)
+	}
+	return nil
+}
+
+// send makes the request, and is the body of the response
+func (p *Client) send(ctx context.Context, method string, path string, reqObj interface{}) ([]byte, error) {
+	var body io.Reader
+	if reqObj != nil {
+		data, err := json.Marshal(reqObj)
+		if err != nil {
+			return nil, errorx.EnsureStackTrace(err)
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, p.BaseUrl+path, body)
+	if err != nil {
+		return nil, errorx.EnsureStackTrace(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := p.HttpClient.Do(req)
+	if err != nil {
+		return nil, errorx.EnsureStackTrace(err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errorx.EnsureStackTrace(err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var apiErr errors.Error
+		_ = json.Unmarshal(data, &apiErr)
+		return nil, &Error{
+			StatusCode: resp.StatusCode,
+			Method:     method,
+			Path:       path,
+			Body:       strings.TrimSpace(string(data)),
+			Code:       apiErr.Code,
+			Message:    apiErr.Message,
+			Field:      apiErr.Field,
+			Errors:     apiErr.Errors,
+		}
+	}
+
+	return data, nil
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
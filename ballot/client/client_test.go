@@ -0,0 +1,534 @@
+package client_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"github.com/papito/ballot/ballot/client"
+	"github.com/papito/ballot/ballot/config"
+	"github.com/papito/ballot/ballot/errors"
+	"github.com/papito/ballot/ballot/hub"
+	"github.com/papito/ballot/ballot/model"
+	"github.com/papito/ballot/ballot/model/response"
+	"github.com/papito/ballot/ballot/server"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var srv server.Server
+var testServer *httptest.Server
+var conns *connTracker
+
+// connTracker remembers hijacked (websocket) connections, so tests can drop them
+type connTracker struct {
+	handler http.Handler
+	mu      sync.Mutex
+	conns   []net.Conn
+}
+
+type hijackRecorder struct {
+	http.ResponseWriter
+	tracker *connTracker
+}
+
+func (p *hijackRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := p.ResponseWriter.(http.Hijacker).Hijack()
+	if err == nil {
+		p.tracker.mu.Lock()
+		p.tracker.conns = append(p.tracker.conns, conn)
+		p.tracker.mu.Unlock()
+	}
+	return conn, rw, err
+}
+
+func (p *connTracker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.handler.ServeHTTP(&hijackRecorder{ResponseWriter: w, tracker: p}, r)
+}
+
+func (p *connTracker) dropAll() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, conn := range p.conns {
+		_ = conn.Close()
+	}
+	p.conns = nil
+}
+
+// The client needs real sockets, so unlike the service tests this runs a server with the Redis-backed hub
+func TestMain(m *testing.M) {
+	err := os.Setenv("ENV", config.DEV)
+	if err != nil {
+		panic(err)
+	}
+
+	log.SetOutput(io.Discard)
+
+	srv = server.NewServer(config.LoadConfig())
+	conns = &connTracker{handler: srv}
+	testServer = httptest.NewServer(conns)
+
+	code := m.Run()
+
+	testServer.Close()
+	srv.Release()
+	os.Exit(code)
+}
+
+func newClient() *client.Client {
+	c := client.New(testServer.URL)
+	c.ReconnectDelay = 10 * time.Millisecond
+	return c
+}
+
+// nextEvent waits for the named event, skipping any others
+func nextEvent(t *testing.T, sub *client.Subscription, name string) client.Event {
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case event, ok := <-sub.Events():
+			if !ok {
+				t.Fatalf("Subscription ended waiting for %s", name)
+			}
+			if event.Name == name {
+				return event
+			}
+		case <-timeout:
+			t.Fatalf("Timed out waiting for %s", name)
+		}
+	}
+}
+
+func TestRestEndpoints(t *testing.T) {
+	ctx := context.Background()
+	c := newClient()
+
+	health, err := c.Health(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "OK", health.Status)
+
+	session, err := c.CreateSession(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, session.SessionId, 36)
+
+	// a session can come with its backlog
+	stories := []model.Story{{Key: "PROJ-1", Title: "Login page"}, {Key: "PROJ-2", Title: "Sign up"}}
+	planned, err := c.CreateSession(ctx, stories...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	queued, err := c.GetBacklog(ctx, planned.SessionId)
+	assert.NoError(t, err)
+	assert.Equal(t, stories, queued)
+
+	user, err := c.CreateUser(ctx, session.SessionId, " Player 1 ", true, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "Player 1", user.Name)
+	assert.True(t, user.IsAdmin)
+
+	fetched, err := c.GetUser(ctx, user.UserId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, user.UserId, fetched.UserId)
+
+	snapshot, err := c.GetSession(ctx, session.SessionId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, session.SessionId, snapshot.SessionId)
+	assert.Equal(t, model.NotVoting, snapshot.SessionState)
+
+	err = c.StartVote(ctx, session.SessionId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vote, err := c.CastVote(ctx, session.SessionId, user.UserId, "5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, model.PendingVote{SessionId: session.SessionId, UserId: user.UserId}, vote)
+
+	err = c.FinishVote(ctx, session.SessionId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := c.ExportSession(ctx, session.SessionId, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var export model.SessionExport
+	assert.NoError(t, json.Unmarshal(data, &export))
+	assert.Equal(t, session.SessionId, export.SessionId)
+	assert.Len(t, export.Rounds, 1)
+
+	data, err = c.ExportSession(ctx, session.SessionId, "csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, strings.HasPrefix(string(data), "Round,Title,Key,Link,Tally,Estimate,Started,Finished"), string(data))
+
+	_, err = c.ExportSession(ctx, session.SessionId, "pdf")
+	apiErr, ok := err.(*client.Error)
+	if assert.True(t, ok, "expected a client error, got %v", err) {
+		assert.Equal(t, http.StatusBadRequest, apiErr.StatusCode)
+		assert.Equal(t, "format", apiErr.Field)
+	}
+
+	_, err = c.CreateUser(ctx, session.SessionId, "  ", false, false)
+	apiErr, ok = err.(*client.Error)
+	if assert.True(t, ok, "expected a client error, got %v", err) {
+		assert.Equal(t, http.StatusBadRequest, apiErr.StatusCode)
+		assert.Equal(t, errors.Validation, apiErr.Code)
+		assert.Equal(t, "user.name", apiErr.Field)
+	}
+
+	_, err = c.GetUser(ctx, "nobody")
+	apiErr, ok = err.(*client.Error)
+	if assert.True(t, ok, "expected a client error, got %v", err) {
+		assert.Equal(t, http.StatusNotFound, apiErr.StatusCode)
+		assert.Equal(t, errors.NotFound, apiErr.Code)
+	}
+}
+
+func TestSubscription(t *testing.T) {
+	ctx := context.Background()
+	c := newClient()
+
+	session, err := c.CreateSession(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := c.CreateUser(ctx, session.SessionId, "Voter", true, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sub := c.Subscribe(ctx, session.SessionId, user.UserId)
+	defer sub.Close()
+
+	watching := nextEvent(t, sub, hub.Event.Watching)
+	assert.Equal(t, model.NotVoting, watching.Session.SessionState)
+	if assert.Len(t, watching.Session.Users, 1) {
+		assert.Equal(t, user.UserId, watching.Session.Users[0].UserId)
+	}
+
+	err = c.StartVote(ctx, session.SessionId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nextEvent(t, sub, response.VoteStartedEVent)
+
+	_, err = c.CastVote(ctx, session.SessionId, user.UserId, "8")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	voted := nextEvent(t, sub, response.UserVotedEVent)
+	assert.Equal(t, user.UserId, voted.Vote.UserId)
+
+	// the only voter voted, so the vote finishes on its own
+	finished := nextEvent(t, sub, response.VoteFinishedEvent)
+	assert.Equal(t, "8", finished.Finished.Tally)
+}
+
+func TestSubscriptionReconnects(t *testing.T) {
+	ctx := context.Background()
+	c := newClient()
+
+	session, err := c.CreateSession(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := c.CreateUser(ctx, session.SessionId, "Flaky", false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sub := c.Subscribe(ctx, session.SessionId, user.UserId)
+	defer sub.Close()
+
+	nextEvent(t, sub, hub.Event.Watching)
+
+	conns.dropAll()
+
+	disconnected := nextEvent(t, sub, client.Disconnected)
+	assert.NotNil(t, disconnected.Err)
+
+	// back in the session with a fresh snapshot
+	watching := nextEvent(t, sub, hub.Event.Watching)
+	if assert.Len(t, watching.Session.Users, 1) {
+		assert.Equal(t, user.UserId, watching.Session.Users[0].UserId)
+	}
+}
+
+func TestSubscriptionClose(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	c := newClient()
+
+	session, err := c.CreateSession(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sub := c.Subscribe(ctx, session.SessionId, "")
+	nextEvent(t, sub, hub.Event.Watching)
+
+	cancel()
+	sub.Close()
+
+	_, ok := <-sub.Events()
+	assert.False(t, ok)
+}
+
+func TestSessionClose(t *testing.T) {
+	ctx := context.Background()
+	c := newClient()
+
+	session, err := c.CreateSession(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	facilitator, err := c.CreateUser(ctx, session.SessionId, "Facilitator", true, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	voter, err := c.CreateUser(ctx, session.SessionId, "Voter", false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	facilitatorSub := c.Subscribe(ctx, session.SessionId, facilitator.UserId)
+	defer facilitatorSub.Close()
+	nextEvent(t, facilitatorSub, hub.Event.Watching)
+	voterSub := c.Subscribe(ctx, session.SessionId, voter.UserId)
+	defer voterSub.Close()
+	nextEvent(t, voterSub, hub.Event.Watching)
+
+	err = c.CloseSession(ctx, session.SessionId, voter.UserId)
+	apiErr, ok := err.(*client.Error)
+	if assert.True(t, ok, "expected a client error, got %v", err) {
+		assert.Equal(t, http.StatusForbidden, apiErr.StatusCode)
+	}
+
+	err = c.CloseSession(ctx, session.SessionId, facilitator.UserId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// everyone is told, and the subscriptions end instead of reconnecting
+	for _, sub := range []*client.Subscription{facilitatorSub, voterSub} {
+		closed := nextEvent(t, sub, response.SessionClosedEvent)
+		assert.Equal(t, session.SessionId, closed.Closed.SessionId)
+
+		select {
+		case event, ok := <-sub.Events():
+			assert.False(t, ok, "unexpected %s event", event.Name)
+		case <-time.After(5 * time.Second):
+			t.Fatal("The subscription did not end")
+		}
+	}
+
+	_, err = c.GetSession(ctx, session.SessionId)
+	apiErr, ok = err.(*client.Error)
+	if assert.True(t, ok, "expected a client error, got %v", err) {
+		assert.Equal(t, http.StatusNotFound, apiErr.StatusCode)
+	}
+}
+
+func TestShutdownKeepsUsersInSession(t *testing.T) {
+	ctx := context.Background()
+
+	// a second instance, to be shut down
+	doomed := server.NewServer(config.LoadConfig())
+	doomedServer := httptest.NewServer(doomed)
+	defer doomedServer.Close()
+
+	c := newClient()
+	session, err := c.CreateSession(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := c.CreateUser(ctx, session.SessionId, "Stayer", false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the user is connected to the doomed instance, someone else watches from the healthy one
+	doomedClient := client.New(doomedServer.URL)
+	doomedClient.ReconnectDelay = time.Hour
+	userSub := doomedClient.Subscribe(ctx, session.SessionId, user.UserId)
+	defer userSub.Close()
+	nextEvent(t, userSub, hub.Event.Watching)
+
+	watcherSub := c.Subscribe(ctx, session.SessionId, "")
+	defer watcherSub.Close()
+	nextEvent(t, watcherSub, hub.Event.Watching)
+
+	doomed.Release()
+
+	nextEvent(t, userSub, response.ServerRestartingEvent)
+	nextEvent(t, userSub, client.Disconnected)
+
+	select {
+	case event := <-watcherSub.Events():
+		t.Errorf("Unexpected event after shutdown: %s", event.Raw)
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	userIds, err := srv.Service().Store().GetSessionVoterIds(ctx, session.SessionId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []string{user.UserId}, userIds)
+}
+
+// redisProxy forwards connections to Redis, so that a test can cut them
+type redisProxy struct {
+	listener net.Listener
+	mu       sync.Mutex
+	conns    []net.Conn
+}
+
+func newRedisProxy(t *testing.T, target string) *redisProxy {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := &redisProxy{listener: listener}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			upstream, err := net.Dial("tcp", target)
+			if err != nil {
+				_ = conn.Close()
+				continue
+			}
+			proxy.mu.Lock()
+			proxy.conns = append(proxy.conns, conn, upstream)
+			proxy.mu.Unlock()
+
+			go func() {
+				_, _ = io.Copy(upstream, conn)
+				_ = upstream.Close()
+			}()
+			go func() {
+				_, _ = io.Copy(conn, upstream)
+				_ = conn.Close()
+			}()
+		}
+	}()
+	return proxy
+}
+
+func (p *redisProxy) dropAll() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, conn := range p.conns {
+		_ = conn.Close()
+	}
+	p.conns = nil
+}
+
+func (p *redisProxy) close() {
+	_ = p.listener.Close()
+	p.dropAll()
+}
+
+func TestSubscribersResubscribe(t *testing.T) {
+	ctx := context.Background()
+
+	// an instance that reaches Redis through a proxy, keeping no idle connections, so that cutting the proxy's
+	// connections only takes the subscribers down
+	conf := config.LoadConfig()
+	conf.RedisPoolMaxIdle = 0
+	redisUrl, err := url.Parse(conf.RedisUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := newRedisProxy(t, redisUrl.Host)
+	defer proxy.close()
+	redisUrl.Host = proxy.listener.Addr().String()
+	conf.RedisUrl = redisUrl.String()
+
+	proxied := server.NewServer(conf)
+	defer proxied.Release()
+	proxiedServer := httptest.NewServer(proxied)
+	defer proxiedServer.Close()
+
+	c := client.New(proxiedServer.URL)
+	c.ReconnectDelay = time.Hour
+
+	session, err := c.CreateSession(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stayer, err := c.CreateUser(ctx, session.SessionId, "Stayer", false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaver, err := c.CreateUser(ctx, session.SessionId, "Leaver", false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stayerSub := c.Subscribe(ctx, session.SessionId, stayer.UserId)
+	defer stayerSub.Close()
+	nextEvent(t, stayerSub, hub.Event.Watching)
+	leaverSub := c.Subscribe(ctx, session.SessionId, leaver.UserId)
+	defer leaverSub.Close()
+	nextEvent(t, leaverSub, hub.Event.Watching)
+
+	proxy.dropAll()
+
+	// the hub subscriber is back on the session once its events reach the socket again
+	heard := false
+	for attempt := 0; attempt < 50 && !heard; attempt++ {
+		_ = proxied.Service().Hub().Emit(ctx, session.SessionId, `{"event": "PING"}`)
+		select {
+		case event := <-stayerSub.Events():
+			heard = event.Name == "PING"
+		case <-time.After(200 * time.Millisecond):
+		}
+	}
+	if !heard {
+		t.Fatal("The session was not heard again after the subscriber reconnected")
+	}
+
+	// and the service subscriber once a user leaving is taken out of the session
+	leaverSub.Close()
+	var userIds []string
+	for attempt := 0; attempt < 50; attempt++ {
+		userIds, err = proxied.Service().Store().GetSessionVoterIds(ctx, session.SessionId)
+		if err == nil && len(userIds) == 1 {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	assert.Equal(t, []string{stayer.UserId}, userIds)
+
+	for component, err := range proxied.Service().Status(ctx) {
+		assert.NoError(t, err, component)
+	}
+}
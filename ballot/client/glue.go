@@ -0,0 +1,191 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"github.com/joomcode/errorx"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+/* A minimal client for the Glue socket protocol the server speaks.
+https://github.com/desertbit/glue
+
+Every frame is a two-letter command followed by its payload. Channel data is framed
+as "cd" + len(channel) + "&" + channel + data, and Socket.Write() on the server side
+always uses the main channel "m".
+*/
+
+const (
+	glueVersion     = "1.9.1"
+	glueMainChannel = "m"
+
+	cmdInit              = "in"
+	cmdPing              = "pi"
+	cmdPong              = "po"
+	cmdClose             = "cl"
+	cmdInvalid           = "iv"
+	cmdDontAutoReconnect = "dr"
+	cmdChannelData       = "cd"
+)
+
+type glueConn struct {
+	conn    *websocket.Conn
+	writeMu sync.Mutex
+}
+
+type watchCommand struct {
+	Action    string `json:"action"`
+	SessionId string `json:"session_id"`
+	UserId    string `json:"user_id,omitempty"`
+}
+
+func socketUrl(baseUrl string) (string, error) {
+	u, err := url.Parse(baseUrl)
+	if err != nil {
+		return "", errorx.EnsureStackTrace(err)
+	}
+
+	switch u.Scheme {
+	case "https":
+		u.Scheme = "wss"
+	default:
+		u.Scheme = "ws"
+	}
+	u.Path = strings.TrimRight(u.Path, "/") + "/glue/ws"
+
+	return u.String(), nil
+}
+
+func dialGlue(ctx context.Context, baseUrl string) (*glueConn, error) {
+	wsUrl, err := socketUrl(baseUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, wsUrl, nil)
+	if err != nil {
+		return nil, errorx.Decorate(err, "connecting to %s", wsUrl)
+	}
+
+	s := &glueConn{conn: conn}
+
+	initData, _ := json.Marshal(map[string]string{"version": glueVersion})
+	err = s.write(cmdInit + string(initData))
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	// the server answers the handshake with its own init frame, carrying the socket ID
+	for {
+		cmd, _, err := s.read()
+		if err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+		if cmd == cmdInit {
+			break
+		}
+		if cmd == cmdDontAutoReconnect {
+			_ = conn.Close()
+			return nil, fmt.Errorf("server does not support socket protocol version %s", glueVersion)
+		}
+	}
+
+	return s, nil
+}
+
+// Watch subscribes the socket to session events. With a user ID, this also puts the user in the session.
+func (p *glueConn) Watch(sessionId string, userId string) error {
+	data, err := json.Marshal(watchCommand{Action: "WATCH", SessionId: sessionId, UserId: userId})
+	if err != nil {
+		return errorx.EnsureStackTrace(err)
+	}
+	return p.send(string(data))
+}
+
+// Next blocks until the next message arrives on the main channel and returns it as raw JSON
+func (p *glueConn) Next() (string, error) {
+	for {
+		cmd, payload, err := p.read()
+		if err != nil {
+			return "", err
+		}
+
+		switch cmd {
+		case cmdPing:
+			err = p.write(cmdPong)
+			if err != nil {
+				return "", err
+			}
+		case cmdChannelData:
+			channel, data, err := unmarshalValues(payload)
+			if err != nil {
+				return "", err
+			}
+			if channel == glueMainChannel {
+				return data, nil
+			}
+		case cmdClose:
+			return "", fmt.Errorf("socket closed by server")
+		case cmdInvalid:
+			return "", fmt.Errorf("server rejected a socket command")
+		}
+	}
+}
+
+func (p *glueConn) Close() error {
+	_ = p.write(cmdClose)
+	return p.conn.Close()
+}
+
+func (p *glueConn) send(data string) error {
+	return p.write(cmdChannelData + marshalValues(glueMainChannel, data))
+}
+
+func (p *glueConn) write(frame string) error {
+	p.writeMu.Lock()
+	defer p.writeMu.Unlock()
+
+	err := p.conn.WriteMessage(websocket.TextMessage, []byte(frame))
+	if err != nil {
+		return errorx.EnsureStackTrace(err)
+	}
+	return nil
+}
+
+func (p *glueConn) read() (string, string, error) {
+	_, data, err := p.conn.ReadMessage()
+	if err != nil {
+		return "", "", errorx.EnsureStackTrace(err)
+	}
+
+	frame := string(data)
+	if len(frame) < 2 {
+		return "", "", fmt.Errorf("invalid socket frame: %q", frame)
+	}
+	return frame[:2], frame[2:], nil
+}
+
+func marshalValues(first string, second string) string {
+	return strconv.Itoa(len(first)) + "&" + first + second
+}
+
+func unmarshalValues(data string) (string, string, error) {
+	size, rest, ok := strings.Cut(data, "&")
+	if !ok {
+		return "", "", fmt.Errorf("invalid channel frame: %q", data)
+	}
+
+	l, err := strconv.Atoi(size)
+	if err != nil || l < 0 || l > len(rest) {
+		return "", "", fmt.Errorf("invalid channel frame: %q", data)
+	}
+
+	return rest[:l], rest[l:], nil
+}
@@ -0,0 +1,187 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/papito/ballot/ballot/hub"
+	"github.com/papito/ballot/ballot/jsonutil"
+	"github.com/papito/ballot/ballot/model/response"
+	"time"
+)
+
+// Disconnected is sent on the events channel when the session socket drops.
+// The subscription reconnects on its own, and the server answers with a fresh WATCHING snapshot.
+const Disconnected = "DISCONNECTED"
+
+// Event is a session event. Name is the event name, and the field matching the event is set.
+type Event struct {
+	Name string
+	Raw  string
+
+	Session  *response.WsSession          // WATCHING
+	User     *response.WsNewUser          // USER_ADDED, OBSERVER_ADDED
+	Started  *response.WsVoteStarted      // VOTING
+	Vote     *response.WsUserVote         // USER_VOTED
+	Finished *response.WsVoteFinished     // VOTE_FINISHED
+	Left     *response.WsUserLeftEvent    // USER_LEFT, OBSERVER_LEFT
+	Closed   *response.WsSessionClosed    // SESSION_CLOSED, SESSION_EXPIRED
+	Expiring *response.WsSessionExpiring  // SESSION_EXPIRING
+	Accepted *response.WsEstimateAccepted // ESTIMATE_ACCEPTED
+
+	// Why the connection dropped, for Disconnected
+	Err error
+}
+
+type Subscription struct {
+	events chan Event
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// errSessionClosed ends a subscription when the session is closed or has expired - there is nothing left to reconnect to
+var errSessionClosed = errors.New("session closed")
+
+// Subscribe watches a session until the context is done, the subscription is closed, or the session is closed or expires.
+// With a user ID, the user is also put in the session, and stays there for as long as the subscription lasts.
+func (p *Client) Subscribe(ctx context.Context, sessionId string, userId string) *Subscription {
+	ctx, cancel := context.WithCancel(ctx)
+	sub := &Subscription{
+		events: make(chan Event),
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+
+	go sub.run(ctx, p, sessionId, userId)
+	return sub
+}
+
+// Events is closed when the subscription ends
+func (p *Subscription) Events() <-chan Event {
+	return p.events
+}
+
+func (p *Subscription) Close() {
+	p.cancel()
+	<-p.done
+}
+
+func (p *Subscription) run(ctx context.Context, c *Client, sessionId string, userId string) {
+	defer close(p.done)
+	defer close(p.events)
+
+	delay := c.ReconnectDelay
+
+	for {
+		conn, err := dialGlue(ctx, c.BaseUrl)
+		if err == nil {
+			err = conn.Watch(sessionId, userId)
+			if err == nil {
+				delay = c.ReconnectDelay
+				err = p.pump(ctx, conn)
+			}
+			_ = conn.Close()
+		}
+
+		if ctx.Err() != nil || errors.Is(err, errSessionClosed) {
+			return
+		}
+
+		if !p.emit(ctx, Event{Name: Disconnected, Err: err}) {
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+
+		delay *= 2
+		if delay > c.MaxReconnectDelay {
+			delay = c.MaxReconnectDelay
+		}
+	}
+}
+
+// pump forwards events from one connection until it fails
+func (p *Subscription) pump(ctx context.Context, conn *glueConn) error {
+	connDone := make(chan struct{})
+	defer close(connDone)
+
+	// unblock the read below when the subscription is closed
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = conn.Close()
+		case <-connDone:
+		}
+	}()
+
+	for {
+		data, err := conn.Next()
+		if err != nil {
+			return err
+		}
+
+		event := ParseEvent(data)
+		if !p.emit(ctx, event) {
+			return ctx.Err()
+		}
+		if event.Name == response.SessionClosedEvent || event.Name == response.SessionExpiredEvent {
+			return errSessionClosed
+		}
+	}
+}
+
+func (p *Subscription) emit(ctx context.Context, event Event) bool {
+	select {
+	case p.events <- event:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// ParseEvent decodes a raw session event. Unknown events only have Name and Raw set.
+func ParseEvent(data string) Event {
+	event := Event{Raw: data}
+
+	jsonData, err := jsonutil.GetJsonFromString(data)
+	if err != nil {
+		return event
+	}
+	event.Name, _ = jsonData["event"].(string)
+
+	switch event.Name {
+	case hub.Event.Watching:
+		event.Session = &response.WsSession{}
+		_ = json.Unmarshal([]byte(data), event.Session)
+	case response.UserAddedEvent, response.ObserverAddedEvent:
+		event.User = &response.WsNewUser{}
+		_ = json.Unmarshal([]byte(data), event.User)
+	case response.VoteStartedEVent:
+		event.Started = &response.WsVoteStarted{}
+		_ = json.Unmarshal([]byte(data), event.Started)
+	case response.UserVotedEVent:
+		event.Vote = &response.WsUserVote{}
+		_ = json.Unmarshal([]byte(data), event.Vote)
+	case response.VoteFinishedEvent:
+		event.Finished = &response.WsVoteFinished{}
+		_ = json.Unmarshal([]byte(data), event.Finished)
+	case hub.Event.UserLeft, hub.Event.ObserverLeft:
+		event.Left = &response.WsUserLeftEvent{}
+		_ = json.Unmarshal([]byte(data), event.Left)
+	case response.EstimateAcceptedEvent:
+		event.Accepted = &response.WsEstimateAccepted{}
+		_ = json.Unmarshal([]byte(data), event.Accepted)
+	case response.SessionExpiringEvent:
+		event.Expiring = &response.WsSessionExpiring{}
+		_ = json.Unmarshal([]byte(data), event.Expiring)
+	case response.SessionClosedEvent, response.SessionExpiredEvent:
+		event.Closed = &response.WsSessionClosed{}
+		_ = json.Unmarshal([]byte(data), event.Closed)
+	}
+
+	return event
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/papito/ballot/ballot/backlog"
+	"github.com/papito/ballot/ballot/client"
+	"github.com/papito/ballot/ballot/errors"
+	"github.com/papito/ballot/ballot/model"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+)
+
+/* ballotctl drives a Ballot server from the command line.
+
+The REST commands print the JSON the server returns, so they compose with jq and friends.
+"join" and "watch" keep the session socket open and print events as they arrive,
+one per line - human-readable by default, or raw JSON with -json.
+*/
+
+const usage = `Usage: ballotctl [-server URL] <command> [flags]
+
+Commands:
+  new    [-backlog FILE]                        create a session, with the stories of a CSV or JSON file queued
+  join   -session ID -name NAME [-observer] [-admin] [-json]
+                                                join a session and stream its events
+  user   -id ID                                 show a user
+  start  -session ID                            start a vote
+  finish -session ID                            finish a vote
+  reopen -session ID -user ID                   reopen a finished vote, keeping the votes cast, as a facilitator
+  vote   -session ID -user ID -estimate VALUE   cast a vote
+  accept -session ID -user ID -estimate VALUE   accept an agreed estimate for the last round, as a facilitator
+  watch  -session ID [-json]                    stream session events without joining
+  backlog -session ID [-file FILE]              queue the stories of a CSV or JSON file, and show the backlog
+  tui    -session ID (-name NAME [-observer] [-admin] | -user ID)
+                                                vote from a full-screen terminal UI
+
+The server URL defaults to $BALLOT_URL, or http://localhost:8080.
+`
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	err := run(ctx, os.Args[1:], os.Stdout)
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "ballotctl: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, args []string, out io.Writer) error {
+	defaultServer := os.Getenv("BALLOT_URL")
+	if defaultServer == "" {
+		defaultServer = "http://localhost:8080"
+	}
+
+	global := flag.NewFlagSet("ballotctl", flag.ContinueOnError)
+	global.Usage = func() { _, _ = fmt.Fprint(global.Output(), usage) }
+	serverUrl := global.String("server", defaultServer, "Ballot server URL")
+
+	err := global.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if global.NArg() == 0 {
+		global.Usage()
+		return fmt.Errorf("no command given")
+	}
+
+	command, cmdArgs := global.Arg(0), global.Args()[1:]
+	c := client.New(*serverUrl)
+
+	switch command {
+	case "new":
+		fs := flag.NewFlagSet("new", flag.ContinueOnError)
+		file := fs.String("backlog", "", "CSV or JSON backlog to queue")
+		if err := parse(fs, cmdArgs); err != nil {
+			return err
+		}
+
+		var stories []model.Story
+		if *file != "" {
+			stories, err = readBacklog(*file)
+			if err != nil {
+				return err
+			}
+		}
+		session, err := c.CreateSession(ctx, stories...)
+		if err != nil {
+			return err
+		}
+		return printJson(out, session)
+
+	case "join":
+		fs := flag.NewFlagSet("join", flag.ContinueOnError)
+		sessionId := fs.String("session", "", "session ID")
+		name := fs.String("name", "", "user name")
+		isObserver := fs.Bool("observer", false, "join as an observer")
+		isAdmin := fs.Bool("admin", false, "join as the session admin")
+		asJson := fs.Bool("json", false, "print raw JSON events")
+		if err := parse(fs, cmdArgs, "session", "name"); err != nil {
+			return err
+		}
+
+		user, err := c.CreateUser(ctx, *sessionId, *name, *isAdmin, *isObserver)
+		if err != nil {
+			return err
+		}
+		if err := printJson(out, user); err != nil {
+			return err
+		}
+
+		return watch(ctx, c, *sessionId, user.UserId, *asJson, out)
+
+	case "user":
+		fs := flag.NewFlagSet("user", flag.ContinueOnError)
+		userId := fs.String("id", "", "user ID")
+		if err := parse(fs, cmdArgs, "id"); err != nil {
+			return err
+		}
+
+		user, err := c.GetUser(ctx, *userId)
+		if err != nil {
+			return err
+		}
+		return printJson(out, user)
+
+	case "start", "finish":
+		fs := flag.NewFlagSet(command, flag.ContinueOnError)
+		sessionId := fs.String("session", "", "session ID")
+		if err := parse(fs, cmdArgs, "session"); err != nil {
+			return err
+		}
+
+		if command == "start" {
+			return c.StartVote(ctx, *sessionId)
+		}
+		return c.FinishVote(ctx, *sessionId)
+
+	case "reopen":
+		fs := flag.NewFlagSet("reopen", flag.ContinueOnError)
+		sessionId := fs.String("session", "", "session ID")
+		userId := fs.String("user", "", "facilitator user ID")
+		if err := parse(fs, cmdArgs, "session", "user"); err != nil {
+			return err
+		}
+		return c.ReopenVote(ctx, *sessionId, *userId)
+
+	case "vote":
+		fs := flag.NewFlagSet("vote", flag.ContinueOnError)
+		sessionId := fs.String("session", "", "session ID")
+		userId := fs.String("user", "", "user ID")
+		estimate := fs.String("estimate", "", "estimate")
+		if err := parse(fs, cmdArgs, "session", "user", "estimate"); err != nil {
+			return err
+		}
+
+		vote, err := c.CastVote(ctx, *sessionId, *userId, *estimate)
+		if err != nil {
+			return err
+		}
+		return printJson(out, vote)
+
+	case "accept":
+		fs := flag.NewFlagSet("accept", flag.ContinueOnError)
+		sessionId := fs.String("session", "", "session ID")
+		userId := fs.String("user", "", "facilitator user ID")
+		estimate := fs.String("estimate", "", "agreed estimate")
+		if err := parse(fs, cmdArgs, "session", "user", "estimate"); err != nil {
+			return err
+		}
+
+		round, err := c.AcceptEstimate(ctx, *sessionId, *userId, *estimate)
+		if err != nil {
+			return err
+		}
+		return printJson(out, round)
+
+	case "watch":
+		fs := flag.NewFlagSet("watch", flag.ContinueOnError)
+		sessionId := fs.String("session", "", "session ID")
+		asJson := fs.Bool("json", false, "print raw JSON events")
+		if err := parse(fs, cmdArgs, "session"); err != nil {
+			return err
+		}
+
+		return watch(ctx, c, *sessionId, "", *asJson, out)
+
+	case "backlog":
+		fs := flag.NewFlagSet("backlog", flag.ContinueOnError)
+		sessionId := fs.String("session", "", "session ID")
+		file := fs.String("file", "", "CSV or JSON backlog to queue")
+		if err := parse(fs, cmdArgs, "session"); err != nil {
+			return err
+		}
+
+		if *file == "" {
+			stories, err := c.GetBacklog(ctx, *sessionId)
+			if err != nil {
+				return err
+			}
+			return printJson(out, stories)
+		}
+
+		stories, err := readBacklog(*file)
+		if err != nil {
+			return err
+		}
+		queued, err := c.QueueStories(ctx, *sessionId, stories)
+		if err != nil {
+			return err
+		}
+		return printJson(out, queued)
+
+	case "tui":
+		fs := flag.NewFlagSet("tui", flag.ContinueOnError)
+		sessionId := fs.String("session", "", "session ID")
+		name := fs.String("name", "", "user name, to join as a new user")
+		userId := fs.String("user", "", "user ID, to rejoin as an existing user")
+		isObserver := fs.Bool("observer", false, "join as an observer")
+		isAdmin := fs.Bool("admin", false, "join as the session admin")
+		if err := parse(fs, cmdArgs, "session"); err != nil {
+			return err
+		}
+
+		var user model.User
+		var err error
+		switch {
+		case *userId != "":
+			user, err = c.GetUser(ctx, *userId)
+		case *name != "":
+			user, err = c.CreateUser(ctx, *sessionId, *name, *isAdmin, *isObserver)
+		default:
+			return fmt.Errorf("tui: -name or -user is required")
+		}
+		if err != nil {
+			return err
+		}
+
+		return runTui(ctx, c, *sessionId, user)
+
+	default:
+		global.Usage()
+		return fmt.Errorf("unknown command %q", command)
+	}
+}
+
+// readBacklog reads the stories of a CSV or JSON file. They are checked here, to point at the rows of the file.
+func readBacklog(file string) ([]model.Story, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	contentType := "application/json"
+	if strings.EqualFold(filepath.Ext(file), ".csv") {
+		contentType = "text/csv"
+	}
+
+	stories, err := backlog.Parse(contentType, data)
+	if err != nil {
+		return nil, rowErrors(file, err)
+	}
+	return stories, nil
+}
+
+// rowErrors lists the errors of a backlog file by row
+func rowErrors(file string, err error) error {
+	apiErr, ok := errors.As(err)
+	if !ok || (len(apiErr.Errors) == 0 && apiErr.Row == 0) {
+		return fmt.Errorf("%s: %w", file, err)
+	}
+
+	lines := []string{fmt.Sprintf("%s: %s", file, apiErr.Message)}
+	if apiErr.Row != 0 {
+		lines = append(lines, fmt.Sprintf("  row %d", apiErr.Row))
+	}
+	for _, rowErr := range apiErr.Errors {
+		lines = append(lines, fmt.Sprintf("  row %d, %s: %s", rowErr.Row, rowErr.Field, rowErr.Message))
+	}
+	return fmt.Errorf("%s", strings.Join(lines, "\n"))
+}
+
+// parse parses command flags and makes sure the required ones are set
+func parse(fs *flag.FlagSet, args []string, required ...string) error {
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range required {
+		if strings.TrimSpace(fs.Lookup(name).Value.String()) == "" {
+			return fmt.Errorf("%s: -%s is required", fs.Name(), name)
+		}
+	}
+	return nil
+}
+
+func printJson(out io.Writer, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(out, string(data))
+	return err
+}
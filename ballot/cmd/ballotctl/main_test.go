@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"github.com/papito/ballot/ballot/model"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		required []string
+		err      string
+	}{
+		{"all set", []string{"-session", "s1", "-user", "u1", "-estimate", "3"}, []string{"session", "user", "estimate"}, ""},
+		{"none required", nil, nil, ""},
+		{"optional left out", []string{"-session", "s1"}, []string{"session"}, ""},
+		{"required left out", []string{"-session", "s1", "-user", "u1"}, []string{"session", "user", "estimate"}, "vote: -estimate is required"},
+		{"required blank", []string{"-session", "  "}, []string{"session"}, "vote: -session is required"},
+		{"unknown flag", []string{"-sesion", "s1"}, []string{"session"}, "flag provided but not defined: -sesion"},
+		{"flag without a value", []string{"-session"}, []string{"session"}, "flag needs an argument: -session"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fs := flag.NewFlagSet("vote", flag.ContinueOnError)
+			fs.SetOutput(io.Discard)
+			fs.String("session", "", "session ID")
+			fs.String("user", "", "user ID")
+			fs.String("estimate", "", "estimate")
+
+			err := parse(fs, test.args, test.required...)
+			if test.err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, test.err)
+			}
+		})
+	}
+}
+
+// Bad arguments are turned down before anything is sent to the server
+func TestRunArguments(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		err  string
+	}{
+		{"no command", nil, "no command given"},
+		{"unknown command", []string{"vot"}, `unknown command "vot"`},
+		{"missing flag", []string{"vote", "-session", "s1", "-user", "u1"}, "vote: -estimate is required"},
+		{"missing flag after the server", []string{"-server", "http://ballot.test", "start"}, "start: -session is required"},
+		{"tui without a user", []string{"tui", "-session", "s1"}, "tui: -name or -user is required"},
+		{"new with a missing backlog", []string{"new", "-backlog", "missing.csv"}, "open missing.csv: no such file or directory"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := run(context.Background(), test.args, io.Discard)
+			assert.EqualError(t, err, test.err)
+		})
+	}
+}
+
+func TestReadBacklog(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, data string) string {
+		file := filepath.Join(dir, name)
+		assert.NoError(t, os.WriteFile(file, []byte(data), 0o600))
+		return file
+	}
+
+	stories, err := readBacklog(write("sprint.csv", "key,title\nPROJ-1,Login page\nPROJ-2,Sign up\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, []model.Story{{Key: "PROJ-1", Title: "Login page"}, {Key: "PROJ-2", Title: "Sign up"}}, stories)
+
+	stories, err = readBacklog(write("sprint.json", `[{"key":"PROJ-3","title":"Search"}]`))
+	assert.NoError(t, err)
+	assert.Equal(t, []model.Story{{Key: "PROJ-3", Title: "Search"}}, stories)
+
+	// the errors point at the rows of the file
+	file := write("broken.csv", "key,title\nPROJ-1,\n")
+	_, err = readBacklog(file)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), file+": ")
+		assert.Contains(t, err.Error(), "row 2, title")
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/papito/ballot/ballot/client"
+	"github.com/papito/ballot/ballot/hub"
+	"github.com/papito/ballot/ballot/model"
+	"github.com/papito/ballot/ballot/model/response"
+	"io"
+	"os"
+	"strings"
+)
+
+/* The event stream of "join" and "watch". The socket is the client package's business; what is left here is
+printing the events, one per line.
+*/
+
+func watch(ctx context.Context, c *client.Client, sessionId string, userId string, asJson bool, out io.Writer) error {
+	sub := c.Subscribe(ctx, sessionId, userId)
+	defer sub.Close()
+
+	names := map[string]string{}
+
+	for event := range sub.Events() {
+		var err error
+		switch {
+		case event.Name == client.Disconnected:
+			_, err = fmt.Fprintf(os.Stderr, "ballotctl: %v, reconnecting\n", event.Err)
+		case asJson:
+			_, err = fmt.Fprintln(out, event.Raw)
+		default:
+			_, err = fmt.Fprintln(out, describeEvent(event, names))
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// describeEvent renders a session event as a line of text. Names are remembered as users
+// show up, since some events only carry the user ID.
+func describeEvent(event client.Event, names map[string]string) string {
+	nameOf := func(userId string) string {
+		if name, ok := names[userId]; ok {
+			return name
+		}
+		return userId
+	}
+
+	switch event.Name {
+	case hub.Event.Watching:
+		state := "idle"
+		if event.Session.SessionState == model.Voting {
+			state = "voting"
+		}
+
+		lines := []string{fmt.Sprintf("%-14s state=%s tally=%q estimate=%q",
+			event.Name, state, event.Session.Tally, event.Session.Estimate)}
+		for _, user := range event.Session.Users {
+			names[user.UserId] = user.Name
+			lines = append(lines, "  voter    "+describeUser(user))
+		}
+		for _, user := range event.Session.Observers {
+			names[user.UserId] = user.Name
+			lines = append(lines, "  observer "+user.Name)
+		}
+		return strings.Join(lines, "\n")
+
+	case response.UserAddedEvent, response.ObserverAddedEvent:
+		names[event.User.UserId] = event.User.Name
+		return fmt.Sprintf("%-14s %s", event.Name, event.User.Name)
+
+	case response.VoteStartedEVent:
+		if !event.Started.Reopened {
+			return event.Name
+		}
+		lines := []string{fmt.Sprintf("%-14s reopened", event.Name)}
+		for _, user := range event.Started.Users {
+			names[user.UserId] = user.Name
+			lines = append(lines, "  "+describeUser(user))
+		}
+		return strings.Join(lines, "\n")
+
+	case response.UserVotedEVent:
+		return fmt.Sprintf("%-14s %s", event.Name, nameOf(event.Vote.UserId))
+
+	case response.VoteFinishedEvent:
+		lines := []string{fmt.Sprintf("%-14s tally=%q", event.Name, event.Finished.Tally)}
+		for _, user := range event.Finished.Users {
+			lines = append(lines, "  "+describeUser(user))
+		}
+		return strings.Join(lines, "\n")
+
+	case response.EstimateAcceptedEvent:
+		return fmt.Sprintf("%-14s estimate=%q tally=%q", event.Name, event.Accepted.Estimate, event.Accepted.Tally)
+
+	case hub.Event.UserLeft, hub.Event.ObserverLeft:
+		return fmt.Sprintf("%-14s %s", event.Name, nameOf(event.Left.UserId))
+
+	default:
+		return fmt.Sprintf("%-14s %s", event.Name, event.Raw)
+	}
+}
+
+func describeUser(user model.User) string {
+	switch {
+	case user.Estimate != model.NoEstimate:
+		return fmt.Sprintf("%-20s %s", user.Name, user.Estimate)
+	case user.Voted:
+		return fmt.Sprintf("%-20s voted", user.Name)
+	default:
+		return user.Name
+	}
+}
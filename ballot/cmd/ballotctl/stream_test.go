@@ -0,0 +1,137 @@
+package main
+
+import (
+	"github.com/papito/ballot/ballot/client"
+	"github.com/papito/ballot/ballot/hub"
+	"github.com/papito/ballot/ballot/model"
+	"github.com/papito/ballot/ballot/model/response"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestDescribeEvent(t *testing.T) {
+	alice := model.User{UserId: "u1", Name: "Alice"}
+	bob := model.User{UserId: "u2", Name: "Bob"}
+	carol := model.User{UserId: "u3", Name: "Carol", IsObserver: true}
+
+	tests := []struct {
+		name  string
+		event client.Event
+		// known before the event, and after it
+		names map[string]string
+		known map[string]string
+		line  string
+	}{
+		{
+			name: "watching",
+			event: client.Event{Name: hub.Event.Watching, Session: &response.WsSession{
+				SessionState: model.Voting,
+				Users:        []model.User{alice, {UserId: "u2", Name: "Bob", Voted: true}},
+				Observers:    []model.User{carol},
+			}},
+			names: map[string]string{},
+			known: map[string]string{"u1": "Alice", "u2": "Bob", "u3": "Carol"},
+			line: "WATCHING       state=voting tally=\"\" estimate=\"\"\n" +
+				"  voter    Alice\n" +
+				"  voter    Bob                  voted\n" +
+				"  observer Carol",
+		},
+		{
+			name:  "watching a finished vote",
+			event: client.Event{Name: hub.Event.Watching, Session: &response.WsSession{Tally: "3 - 5", Estimate: "5"}},
+			names: map[string]string{},
+			known: map[string]string{},
+			line:  `WATCHING       state=idle tally="3 - 5" estimate="5"`,
+		},
+		{
+			name:  "user added",
+			event: client.Event{Name: response.UserAddedEvent, User: &response.WsNewUser{User: alice}},
+			names: map[string]string{},
+			known: map[string]string{"u1": "Alice"},
+			line:  "USER_ADDED     Alice",
+		},
+		{
+			name:  "observer added",
+			event: client.Event{Name: response.ObserverAddedEvent, User: &response.WsNewUser{User: carol}},
+			names: map[string]string{},
+			known: map[string]string{"u3": "Carol"},
+			line:  "OBSERVER_ADDED Carol",
+		},
+		{
+			name:  "vote started",
+			event: client.Event{Name: response.VoteStartedEVent, Started: &response.WsVoteStarted{}},
+			names: map[string]string{},
+			known: map[string]string{},
+			line:  "VOTING",
+		},
+		{
+			name: "vote reopened",
+			event: client.Event{Name: response.VoteStartedEVent, Started: &response.WsVoteStarted{
+				Reopened: true,
+				Users:    []model.User{{UserId: "u1", Name: "Alice", Voted: true}, bob},
+			}},
+			names: map[string]string{},
+			known: map[string]string{"u1": "Alice", "u2": "Bob"},
+			line:  "VOTING         reopened\n  Alice                voted\n  Bob",
+		},
+		{
+			name:  "known user voted",
+			event: client.Event{Name: response.UserVotedEVent, Vote: &response.WsUserVote{UserId: "u1"}},
+			names: map[string]string{"u1": "Alice"},
+			known: map[string]string{"u1": "Alice"},
+			line:  "USER_VOTED     Alice",
+		},
+		{
+			name:  "unknown user voted",
+			event: client.Event{Name: response.UserVotedEVent, Vote: &response.WsUserVote{UserId: "u9"}},
+			names: map[string]string{},
+			known: map[string]string{},
+			line:  "USER_VOTED     u9",
+		},
+		{
+			name: "vote finished",
+			event: client.Event{Name: response.VoteFinishedEvent, Finished: &response.WsVoteFinished{
+				Tally: "3 - 5",
+				Users: []model.User{{UserId: "u1", Name: "Alice", Estimate: "3"}, {UserId: "u2", Name: "Bob", Estimate: "5"}},
+			}},
+			names: map[string]string{},
+			known: map[string]string{},
+			line:  "VOTE_FINISHED  tally=\"3 - 5\"\n  Alice                3\n  Bob                  5",
+		},
+		{
+			name:  "estimate accepted",
+			event: client.Event{Name: response.EstimateAcceptedEvent, Accepted: &response.WsEstimateAccepted{Round: 1, Tally: "3 - 5", Estimate: "5"}},
+			names: map[string]string{},
+			known: map[string]string{},
+			line:  `ESTIMATE_ACCEPTED estimate="5" tally="3 - 5"`,
+		},
+		{
+			name:  "user left",
+			event: client.Event{Name: hub.Event.UserLeft, Left: &response.WsUserLeftEvent{UserId: "u2"}},
+			names: map[string]string{"u2": "Bob"},
+			known: map[string]string{"u2": "Bob"},
+			line:  "USER_LEFT      Bob",
+		},
+		{
+			name:  "observer left",
+			event: client.Event{Name: hub.Event.ObserverLeft, Left: &response.WsUserLeftEvent{UserId: "u3"}},
+			names: map[string]string{},
+			known: map[string]string{},
+			line:  "OBSERVER_LEFT  u3",
+		},
+		{
+			name:  "any other event",
+			event: client.Event{Name: response.SessionClosedEvent, Raw: `{"event":"SESSION_CLOSED","session_id":"s1"}`},
+			names: map[string]string{},
+			known: map[string]string{},
+			line:  `SESSION_CLOSED {"event":"SESSION_CLOSED","session_id":"s1"}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.line, describeEvent(test.event, test.names))
+			assert.Equal(t, test.known, test.names)
+		})
+	}
+}
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/joomcode/errorx"
+	"github.com/papito/ballot/ballot/client"
+	"github.com/papito/ballot/ballot/hub"
+	"github.com/papito/ballot/ballot/model"
+	"github.com/papito/ballot/ballot/model/response"
+	"golang.org/x/term"
+	"os"
+	"strings"
+)
+
+/* A full-screen voting client. It keeps the same picture of the session as the web UI
+(see ballot-ui/src/features/vote_manager.ts), built from the same socket events,
+and redraws the whole screen whenever something changes.
+*/
+
+// Same deck as the web UI
+var cardValues = []string{"?", "0", "1", "2", "3", "5", "8", "13", "20", "40", "100"}
+
+const (
+	ansiClear      = "\x1b[H\x1b[2J"
+	ansiAltScreen  = "\x1b[?1049h"
+	ansiMainScreen = "\x1b[?1049l"
+	ansiHideCursor = "\x1b[?25l"
+	ansiShowCursor = "\x1b[?25h"
+	ansiBold       = "\x1b[1m"
+	ansiDim        = "\x1b[2m"
+	ansiReverse    = "\x1b[7m"
+	ansiReset      = "\x1b[0m"
+)
+
+type key int
+
+const (
+	keyOther key = iota
+	keyLeft
+	keyRight
+	keyEnter
+	keyStart
+	keyFinish
+	keyQuit
+)
+
+type tui struct {
+	ctx       context.Context
+	client    *client.Client
+	sessionId string
+	user      model.User
+
+	sessionState int
+	voters       []model.User
+	observers    []model.User
+	tally        string
+	// accepted by a facilitator, in place of the tally
+	estimate string
+
+	cursor int
+	status string
+}
+
+func runTui(ctx context.Context, c *client.Client, sessionId string, user model.User) error {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return fmt.Errorf("tui needs a terminal")
+	}
+
+	oldState, err := term.MakeRaw(fd)
+	if err != nil {
+		return errorx.EnsureStackTrace(err)
+	}
+	defer func() { _ = term.Restore(fd, oldState) }()
+
+	fmt.Print(ansiAltScreen + ansiHideCursor)
+	defer fmt.Print(ansiShowCursor + ansiMainScreen)
+
+	sub := c.Subscribe(ctx, sessionId, user.UserId)
+	defer sub.Close()
+
+	keys := make(chan key)
+	go readKeys(keys)
+
+	t := &tui{ctx: ctx, client: c, sessionId: sessionId, user: user}
+	t.draw()
+
+	for {
+		select {
+		case event, ok := <-sub.Events():
+			if !ok {
+				return nil
+			}
+			t.apply(event)
+		case k := <-keys:
+			if k == keyQuit {
+				return nil
+			}
+			t.handleKey(k)
+		}
+		t.draw()
+	}
+}
+
+func readKeys(keys chan<- key) {
+	buf := make([]byte, 16)
+	for {
+		n, err := os.Stdin.Read(buf)
+		if err != nil {
+			keys <- keyQuit
+			return
+		}
+
+		input := string(buf[:n])
+		switch {
+		case input == "\x1b[D" || input == "h":
+			keys <- keyLeft
+		case input == "\x1b[C" || input == "l":
+			keys <- keyRight
+		case input == "\r" || input == "\n" || input == " ":
+			keys <- keyEnter
+		case input == "s":
+			keys <- keyStart
+		case input == "f":
+			keys <- keyFinish
+		case input == "q" || input == "\x03" || input == "\x04":
+			keys <- keyQuit
+		default:
+			keys <- keyOther
+		}
+	}
+}
+
+func (p *tui) handleKey(k key) {
+	p.status = ""
+
+	switch k {
+	case keyLeft:
+		if p.cursor > 0 {
+			p.cursor--
+		}
+	case keyRight:
+		if p.cursor < len(cardValues)-1 {
+			p.cursor++
+		}
+	case keyEnter:
+		if p.user.IsObserver || p.sessionState != model.Voting {
+			return
+		}
+		estimate := cardValues[p.cursor]
+		_, err := p.client.CastVote(p.ctx, p.sessionId, p.user.UserId, estimate)
+		if err != nil {
+			p.status = err.Error()
+			return
+		}
+		p.user.Estimate = estimate
+		p.user.Voted = true
+	case keyStart, keyFinish:
+		if !p.user.IsAdmin {
+			p.status = "Only the session admin can start and finish votes"
+			return
+		}
+
+		var err error
+		if k == keyStart {
+			err = p.client.StartVote(p.ctx, p.sessionId)
+		} else {
+			err = p.client.FinishVote(p.ctx, p.sessionId)
+		}
+		if err != nil {
+			p.status = err.Error()
+		}
+	}
+}
+
+// apply updates the session picture with an event from the session socket
+func (p *tui) apply(event client.Event) {
+	switch event.Name {
+	case client.Disconnected:
+		p.status = fmt.Sprintf("Connection lost (%v), reconnecting...", event.Err)
+
+	case hub.Event.Watching:
+		p.status = ""
+		p.sessionState = event.Session.SessionState
+		p.tally = event.Session.Tally
+		p.estimate = event.Session.Estimate
+		p.voters = event.Session.Users
+		p.observers = event.Session.Observers
+
+	case response.UserAddedEvent:
+		if indexOf(p.voters, event.User.UserId) < 0 {
+			p.voters = append(p.voters, event.User.User)
+		}
+
+	case response.ObserverAddedEvent:
+		if indexOf(p.observers, event.User.UserId) < 0 {
+			p.observers = append(p.observers, event.User.User)
+		}
+
+	case response.VoteStartedEVent:
+		p.sessionState = model.Voting
+		p.tally = ""
+		p.estimate = ""
+		if event.Started.Reopened {
+			// the votes cast stand, and the estimates are only known again once the vote is finished
+			p.voters = event.Started.Users
+			if idx := indexOf(p.voters, p.user.UserId); idx >= 0 {
+				p.user.Voted = p.voters[idx].Voted
+			}
+			break
+		}
+		for idx := range p.voters {
+			p.voters[idx].Voted = false
+			p.voters[idx].Estimate = model.NoEstimate
+		}
+		p.user.Voted = false
+		p.user.Estimate = model.NoEstimate
+
+	case response.EstimateAcceptedEvent:
+		p.estimate = event.Accepted.Estimate
+
+	case response.UserVotedEVent:
+		if idx := indexOf(p.voters, event.Vote.UserId); idx >= 0 {
+			p.voters[idx].Voted = true
+		}
+
+	case response.VoteFinishedEvent:
+		p.sessionState = model.NotVoting
+		p.tally = event.Finished.Tally
+		p.voters = event.Finished.Users
+
+	case hub.Event.UserLeft:
+		userId := event.Left.UserId
+		// the same user may have the session open somewhere else
+		if idx := indexOf(p.voters, userId); idx >= 0 && userId != p.user.UserId {
+			p.voters = append(p.voters[:idx], p.voters[idx+1:]...)
+		}
+
+	case hub.Event.ObserverLeft:
+		if idx := indexOf(p.observers, event.Left.UserId); idx >= 0 {
+			p.observers = append(p.observers[:idx], p.observers[idx+1:]...)
+		}
+	}
+}
+
+func (p *tui) draw() {
+	lines := make([]string, 0)
+
+	role := "voter"
+	if p.user.IsObserver {
+		role = "observer"
+	}
+	if p.user.IsAdmin {
+		role += ", admin"
+	}
+	lines = append(lines,
+		fmt.Sprintf(" %sBallot%s  %s (%s)", ansiBold, ansiReset, p.user.Name, role),
+		fmt.Sprintf(" %ssession %s%s", ansiDim, p.sessionId, ansiReset),
+		"")
+
+	switch {
+	case p.sessionState == model.Voting && p.user.IsObserver:
+		lines = append(lines, " Voting in progress...")
+	case p.sessionState == model.Voting && !p.user.Voted:
+		lines = append(lines, " "+ansiBold+"Pick a card!"+ansiReset)
+	case p.sessionState == model.Voting:
+		lines = append(lines, " Waiting for the others to vote...")
+	case p.estimate != "":
+		lines = append(lines, fmt.Sprintf(" %sEstimate: %s%s (tally %s)", ansiBold, p.estimate, ansiReset, p.tally))
+	case p.tally != "":
+		lines = append(lines, fmt.Sprintf(" %sEstimate: %s%s", ansiBold, p.tally, ansiReset))
+	case p.user.IsAdmin:
+		lines = append(lines, " Press s to start a vote")
+	default:
+		lines = append(lines, " Waiting for admin to start next vote...")
+	}
+	lines = append(lines, "")
+
+	lines = append(lines, " Voters")
+	for _, voter := range p.voters {
+		mark := " "
+		if voter.Voted {
+			mark = "✓"
+		}
+
+		estimate := ""
+		if p.sessionState == model.NotVoting {
+			estimate = voter.Estimate
+		}
+
+		name := voter.Name
+		if voter.UserId == p.user.UserId {
+			name += " (you)"
+		}
+		lines = append(lines, fmt.Sprintf("   %s %-24s %s", mark, name, estimate))
+	}
+
+	if len(p.observers) > 0 {
+		names := make([]string, 0, len(p.observers))
+		for _, observer := range p.observers {
+			names = append(names, observer.Name)
+		}
+		lines = append(lines, "", " Watching: "+strings.Join(names, ", "))
+	}
+	lines = append(lines, "")
+
+	if p.sessionState == model.Voting && !p.user.IsObserver {
+		cards := make([]string, 0, len(cardValues))
+		for idx, value := range cardValues {
+			card := fmt.Sprintf("[%3s ]", value)
+			switch {
+			case idx == p.cursor:
+				card = ansiReverse + card + ansiReset
+			case value == p.user.Estimate:
+				card = ansiBold + card + ansiReset
+			}
+			cards = append(cards, card)
+		}
+		lines = append(lines, " "+strings.Join(cards, " "), "")
+	}
+
+	help := " ←/→ pick  enter vote  q quit"
+	if p.user.IsAdmin {
+		help = " ←/→ pick  enter vote  s start  f finish  q quit"
+	}
+	lines = append(lines, ansiDim+help+ansiReset)
+
+	if p.status != "" {
+		lines = append(lines, "", " "+p.status)
+	}
+
+	// the terminal is in raw mode, so lines need explicit carriage returns
+	fmt.Print(ansiClear + strings.Join(lines, "\r\n"))
+}
+
+func indexOf(users []model.User, userId string) int {
+	for idx, user := range users {
+		if user.UserId == userId {
+			return idx
+		}
+	}
+	return -1
+}
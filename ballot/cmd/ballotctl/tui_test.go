@@ -0,0 +1,137 @@
+package main
+
+import (
+	"errors"
+	"github.com/papito/ballot/ballot/client"
+	"github.com/papito/ballot/ballot/hub"
+	"github.com/papito/ballot/ballot/model"
+	"github.com/papito/ballot/ballot/model/response"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestTuiApply(t *testing.T) {
+	me := model.User{UserId: "u1", Name: "Alice"}
+	bob := model.User{UserId: "u2", Name: "Bob"}
+	carol := model.User{UserId: "u3", Name: "Carol", IsObserver: true}
+
+	// a vote is on, and both voters have voted
+	voting := func() *tui {
+		return &tui{
+			user:         model.User{UserId: "u1", Name: "Alice", Voted: true, Estimate: "3"},
+			sessionState: model.Voting,
+			voters:       []model.User{{UserId: "u1", Name: "Alice", Voted: true}, {UserId: "u2", Name: "Bob", Voted: true}},
+			observers:    []model.User{carol},
+		}
+	}
+
+	tests := []struct {
+		name  string
+		event client.Event
+		want  func(p *tui)
+	}{
+		{
+			name:  "disconnected",
+			event: client.Event{Name: client.Disconnected, Err: errors.New("EOF")},
+			want:  func(p *tui) { p.status = "Connection lost (EOF), reconnecting..." },
+		},
+		{
+			name: "watching",
+			event: client.Event{Name: hub.Event.Watching, Session: &response.WsSession{
+				SessionState: model.NotVoting, Tally: "3 - 5", Estimate: "5", Users: []model.User{me}, Observers: []model.User{}}},
+			want: func(p *tui) {
+				p.sessionState = model.NotVoting
+				p.tally = "3 - 5"
+				p.estimate = "5"
+				p.voters = []model.User{me}
+				p.observers = []model.User{}
+			},
+		},
+		{
+			name:  "user added",
+			event: client.Event{Name: response.UserAddedEvent, User: &response.WsNewUser{User: model.User{UserId: "u4", Name: "Dave"}}},
+			want: func(p *tui) {
+				p.voters = append(p.voters, model.User{UserId: "u4", Name: "Dave"})
+			},
+		},
+		{
+			name:  "user added twice",
+			event: client.Event{Name: response.UserAddedEvent, User: &response.WsNewUser{User: bob}},
+			want:  func(p *tui) {},
+		},
+		{
+			name:  "observer added",
+			event: client.Event{Name: response.ObserverAddedEvent, User: &response.WsNewUser{User: model.User{UserId: "u5", Name: "Erin"}}},
+			want: func(p *tui) {
+				p.observers = append(p.observers, model.User{UserId: "u5", Name: "Erin"})
+			},
+		},
+		{
+			name:  "vote started",
+			event: client.Event{Name: response.VoteStartedEVent, Started: &response.WsVoteStarted{}},
+			want: func(p *tui) {
+				p.user = me
+				p.voters = []model.User{me, bob}
+			},
+		},
+		{
+			name: "vote reopened",
+			event: client.Event{Name: response.VoteStartedEVent, Started: &response.WsVoteStarted{
+				Reopened: true, Users: []model.User{{UserId: "u1", Name: "Alice"}, {UserId: "u2", Name: "Bob", Voted: true}}}},
+			want: func(p *tui) {
+				p.user.Voted = false
+				p.voters = []model.User{me, {UserId: "u2", Name: "Bob", Voted: true}}
+			},
+		},
+		{
+			name:  "user voted",
+			event: client.Event{Name: response.UserVotedEVent, Vote: &response.WsUserVote{UserId: "u2"}},
+			want:  func(p *tui) {},
+		},
+		{
+			name: "vote finished",
+			event: client.Event{Name: response.VoteFinishedEvent, Finished: &response.WsVoteFinished{
+				Tally: "3 - 5", Users: []model.User{{UserId: "u1", Name: "Alice", Estimate: "3"}, {UserId: "u2", Name: "Bob", Estimate: "5"}}}},
+			want: func(p *tui) {
+				p.sessionState = model.NotVoting
+				p.tally = "3 - 5"
+				p.voters = []model.User{{UserId: "u1", Name: "Alice", Estimate: "3"}, {UserId: "u2", Name: "Bob", Estimate: "5"}}
+			},
+		},
+		{
+			name:  "estimate accepted",
+			event: client.Event{Name: response.EstimateAcceptedEvent, Accepted: &response.WsEstimateAccepted{Estimate: "5"}},
+			want:  func(p *tui) { p.estimate = "5" },
+		},
+		{
+			name:  "user left",
+			event: client.Event{Name: hub.Event.UserLeft, Left: &response.WsUserLeftEvent{UserId: "u2"}},
+			want:  func(p *tui) { p.voters = p.voters[:1] },
+		},
+		{
+			// the same user may have the session open somewhere else
+			name:  "left elsewhere",
+			event: client.Event{Name: hub.Event.UserLeft, Left: &response.WsUserLeftEvent{UserId: "u1"}},
+			want:  func(p *tui) {},
+		},
+		{
+			name:  "observer left",
+			event: client.Event{Name: hub.Event.ObserverLeft, Left: &response.WsUserLeftEvent{UserId: "u3"}},
+			want:  func(p *tui) { p.observers = []model.User{} },
+		},
+		{
+			name:  "any other event",
+			event: client.Event{Name: response.SessionExpiringEvent, Expiring: &response.WsSessionExpiring{}},
+			want:  func(p *tui) {},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, want := voting(), voting()
+			test.want(want)
+			got.apply(test.event)
+			assert.Equal(t, want, got)
+		})
+	}
+}
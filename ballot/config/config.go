@@ -1,15 +1,29 @@
 package config
 
 import (
-	"log"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
-const SessionTtl = 172800 // 48H
-
 const (
 	TEST = "test"
 	DEV  = "development"
+	PROD = "production"
+)
+
+// Brokers fan session events out to sockets. "none" runs without sockets, for tests and API-only instances.
+const (
+	BrokerRedis = "redis"
+	BrokerNone  = "none"
 )
 
 type Config struct {
@@ -17,39 +31,209 @@ type Config struct {
 	HttpHost    string
 	HttpPort    string
 	RedisUrl    string
+
+	// Limit for a single Redis operation. Requests fail with a 504 instead of hanging on a stalled Redis.
+	RedisTimeout     time.Duration
+	RedisDialTimeout time.Duration
+
+	// ACL credentials. They take precedence over any in the Redis URL.
+	RedisUsername string
+	RedisPassword string
+
+	// PEM file with the CA that signed the server certificate, for rediss:// URLs.
+	// The system roots are used when it is not set.
+	RedisTlsCaFile string
+
+	// With Sentinel, the master address is asked from the Sentinels on every new connection,
+	// and the Redis URL only supplies the credentials, TLS and database.
+	RedisSentinelAddrs    []string
+	RedisSentinelMaster   string
+	RedisSentinelPassword string
+
+	// MaxActive 0 is no limit. With a limit, callers wait for a free connection until their context is done.
+	RedisPoolMaxIdle     int
+	RedisPoolMaxActive   int
+	RedisPoolIdleTimeout time.Duration
+
+	// Slack signs slash command requests with a shared secret, Mattermost sends a static token.
+	// Slash commands are rejected unless at least one of these is set.
+	SlashSigningSecret string
+	SlashToken         string
+
+	// The GitHub REST API that sessions connected to GitHub Issues talk to, for GitHub Enterprise
+	GithubApiUrl string
+
+	// Where the sockets may be opened from, besides the server itself. "*" allows any origin.
+	AllowedOrigins []string
+
+	// Directory to serve the UI from, picking up rebuilds. The UI built into the binary is served when empty.
+	StaticPath string
+
+	// Idle sessions, and their users, are forgotten after this long
+	SessionTtl time.Duration
+
+	// Sockets are warned this long before their session expires, 0 for no warning.
+	// Expiring sessions are looked for every SessionSweepInterval.
+	SessionExpiryWarning time.Duration
+	SessionSweepInterval time.Duration
+
+	Broker string
+
+	// Logs are text or json. User names are redacted unless LogUserNames is set.
+	LogLevel     string
+	LogFormat    string
+	LogUserNames bool
+
+	Features Features
+
+	// Set from the command line only
+	ConfigFile  string
+	PrintConfig bool
+	// A command, such as "gc", and its arguments, after the settings
+	Command []string
+}
+
+type Features struct {
+	SlashCommands bool
+	Ui            bool
+	Metrics       bool
 }
 
-func LoadConfig() Config {
-	config := Config{}
+// Validate catches settings that would otherwise only fail on the first Redis call
+func (c Config) Validate() error {
+	var errs []error
+
+	if c.Environment != DEV && c.Environment != PROD && c.Environment != TEST {
+		errs = append(errs, fmt.Errorf("ENV must be %s, %s or %s, not %q", DEV, PROD, TEST, c.Environment))
+	}
+
+	_, err := strconv.Atoi(strings.TrimPrefix(c.HttpPort, ":"))
+	if err != nil {
+		errs = append(errs, fmt.Errorf("HTTP_PORT must be a number, not %q", strings.TrimPrefix(c.HttpPort, ":")))
+	}
 
-	config.Environment = os.Getenv("ENV")
-	if config.Environment == "" {
-		config.Environment = DEV
-		err := os.Setenv("ENV", config.Environment)
+	for _, origin := range c.AllowedOrigins {
+		if origin == "*" {
+			continue
+		}
+		originUrl, err := url.Parse(origin)
+		if err != nil || originUrl.Scheme == "" || originUrl.Host == "" || strings.Trim(originUrl.Path, "/") != "" {
+			errs = append(errs, fmt.Errorf("ALLOWED_ORIGINS: %q is not an origin such as https://example.com", origin))
+		}
+	}
 
+	if c.StaticPath != "" {
+		info, err := os.Stat(c.StaticPath)
 		if err != nil {
-			panic(err)
+			errs = append(errs, fmt.Errorf("STATIC_PATH: %w", err))
+		} else if !info.IsDir() {
+			errs = append(errs, fmt.Errorf("STATIC_PATH: %s is not a directory", c.StaticPath))
 		}
 	}
-	log.Printf("ENV: %s", config.Environment)
 
-	config.HttpPort = ":" + os.Getenv("HTTP_PORT")
-	if config.HttpPort == ":" {
-		config.HttpPort = ":8080"
+	if c.SessionTtl < time.Second {
+		errs = append(errs, fmt.Errorf("SESSION_TTL must be at least a second"))
+	}
+	if c.SessionExpiryWarning < 0 || c.SessionExpiryWarning >= c.SessionTtl {
+		errs = append(errs, fmt.Errorf("SESSION_EXPIRY_WARNING must be between 0 and SESSION_TTL"))
+	}
+	if c.SessionSweepInterval <= 0 {
+		errs = append(errs, fmt.Errorf("SESSION_SWEEP_INTERVAL must be positive"))
 	}
-	log.Printf("HTTP port %s", config.HttpPort)
 
-	config.HttpHost = os.Getenv("HTTP_HOST")
-	if config.HttpHost == "" {
-		config.HttpHost = "http://localhost" + config.HttpPort
+	githubUrl, err := url.Parse(c.GithubApiUrl)
+	if err != nil || (githubUrl.Scheme != "http" && githubUrl.Scheme != "https") || githubUrl.Host == "" {
+		errs = append(errs, fmt.Errorf("GITHUB_API_URL: %q is not an http or https URL", c.GithubApiUrl))
 	}
-	log.Printf("HTTP host %s", config.HttpHost)
 
-	config.RedisUrl = os.Getenv("REDIS_URL")
-	if config.RedisUrl == "" {
-		config.RedisUrl = "redis://localhost:6380"
+	if c.Broker != BrokerRedis && c.Broker != BrokerNone {
+		errs = append(errs, fmt.Errorf("BROKER must be %s or %s, not %q", BrokerRedis, BrokerNone, c.Broker))
 	}
-	log.Printf("Redis URL %s", config.RedisUrl)
 
-	return config
+	var level slog.Level
+	if level.UnmarshalText([]byte(c.LogLevel)) != nil {
+		errs = append(errs, fmt.Errorf("LOG_LEVEL must be debug, info, warn or error, not %q", c.LogLevel))
+	}
+	if c.LogFormat != "text" && c.LogFormat != "json" {
+		errs = append(errs, fmt.Errorf("LOG_FORMAT must be text or json, not %q", c.LogFormat))
+	}
+
+	redisUrl, err := url.Parse(c.RedisUrl)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("REDIS_URL: %w", err))
+	} else if redisUrl.Scheme != "redis" && redisUrl.Scheme != "rediss" {
+		errs = append(errs, fmt.Errorf("REDIS_URL: scheme must be redis:// or rediss://, not %q", redisUrl.Scheme))
+	}
+
+	if c.RedisTlsCaFile != "" {
+		if redisUrl != nil && redisUrl.Scheme != "rediss" {
+			errs = append(errs, fmt.Errorf("REDIS_TLS_CA_FILE is set, but REDIS_URL is not a rediss:// URL"))
+		}
+		_, err = c.RedisTlsConfig()
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if len(c.RedisSentinelAddrs) > 0 && c.RedisSentinelMaster == "" {
+		errs = append(errs, fmt.Errorf("REDIS_SENTINEL_MASTER is required with REDIS_SENTINEL_ADDRS"))
+	}
+	if len(c.RedisSentinelAddrs) == 0 && c.RedisSentinelMaster != "" {
+		errs = append(errs, fmt.Errorf("REDIS_SENTINEL_ADDRS is required with REDIS_SENTINEL_MASTER"))
+	}
+	for _, addr := range c.RedisSentinelAddrs {
+		_, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("REDIS_SENTINEL_ADDRS: %w", err))
+		}
+	}
+
+	if c.RedisPoolMaxIdle < 0 {
+		errs = append(errs, fmt.Errorf("REDIS_POOL_MAX_IDLE cannot be negative"))
+	}
+	if c.RedisPoolMaxActive < 0 {
+		errs = append(errs, fmt.Errorf("REDIS_POOL_MAX_ACTIVE cannot be negative"))
+	}
+	// the hub and the service each hold a connection for pub/sub, for as long as the server runs
+	if c.RedisPoolMaxActive > 0 && c.RedisPoolMaxActive < 3 {
+		errs = append(errs, fmt.Errorf("REDIS_POOL_MAX_ACTIVE must be at least 3, two connections are taken by pub/sub"))
+	}
+	if c.RedisPoolMaxActive > 0 && c.RedisPoolMaxIdle > c.RedisPoolMaxActive {
+		errs = append(errs, fmt.Errorf("REDIS_POOL_MAX_IDLE cannot be more than REDIS_POOL_MAX_ACTIVE"))
+	}
+
+	if c.RedisTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("REDIS_TIMEOUT must be positive"))
+	}
+	if c.RedisDialTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("REDIS_DIAL_TIMEOUT must be positive"))
+	}
+
+	return errors.Join(errs...)
+}
+
+// SlogLevel is LogLevel as a log/slog level. Validate rejects levels it does not know.
+func (c Config) SlogLevel() slog.Level {
+	var level slog.Level
+	_ = level.UnmarshalText([]byte(c.LogLevel))
+	return level
+}
+
+// RedisTlsConfig trusts the CA in RedisTlsCaFile, or the system roots when there is none
+func (c Config) RedisTlsConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if c.RedisTlsCaFile == "" {
+		return tlsConfig, nil
+	}
+
+	pem, err := os.ReadFile(c.RedisTlsCaFile)
+	if err != nil {
+		return nil, fmt.Errorf("REDIS_TLS_CA_FILE: %w", err)
+	}
+
+	tlsConfig.RootCAs = x509.NewCertPool()
+	if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("REDIS_TLS_CA_FILE: no certificates found in %s", c.RedisTlsCaFile)
+	}
+	return tlsConfig, nil
 }
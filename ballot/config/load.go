@@ -0,0 +1,472 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/* Settings come in layers, each one overriding the one before it: the defaults, a YAML file,
+environment variables, and command-line flags. A setting goes by the same name in all of them -
+"session_ttl" in the file, SESSION_TTL in the environment and --session-ttl on the command line.
+Dotted keys, such as "features.ui", are nested in the file.
+*/
+
+type setting struct {
+	key    string
+	env    string
+	usage  string
+	secret bool
+	value  value
+}
+
+func (s setting) flagName() string {
+	return strings.NewReplacer(".", "-", "_", "-").Replace(s.key)
+}
+
+type value interface {
+	Set(string) error
+	String() string
+}
+
+func (p *Config) settings() []setting {
+	return []setting{
+		{key: "env", env: "ENV", usage: "environment: development, production or test",
+			value: stringValue{&p.Environment}},
+		{key: "http_host", env: "HTTP_HOST", usage: "URL the server is reached at, for links to sessions",
+			value: stringValue{&p.HttpHost}},
+		{key: "http_port", env: "HTTP_PORT", usage: "port to listen on",
+			value: portValue{&p.HttpPort}},
+		{key: "static_path", env: "STATIC_PATH", usage: "directory to serve the UI from, instead of the build in the binary",
+			value: stringValue{&p.StaticPath}},
+		{key: "allowed_origins", env: "ALLOWED_ORIGINS", usage: "comma-separated origins sockets may be opened from, or *",
+			value: listValue{&p.AllowedOrigins}},
+		{key: "session_ttl", env: "SESSION_TTL", usage: "how long an idle session is kept",
+			value: durationValue{&p.SessionTtl}},
+		{key: "session_expiry_warning", env: "SESSION_EXPIRY_WARNING", usage: "how long before expiry to warn sockets, 0 for never",
+			value: durationValue{&p.SessionExpiryWarning}},
+		{key: "session_sweep_interval", env: "SESSION_SWEEP_INTERVAL", usage: "how often to look for expiring sessions",
+			value: durationValue{&p.SessionSweepInterval}},
+		{key: "broker", env: "BROKER", usage: "session event broker: redis, or none to run without sockets",
+			value: stringValue{&p.Broker}},
+		{key: "log_level", env: "LOG_LEVEL", usage: "debug, info, warn or error",
+			value: stringValue{&p.LogLevel}},
+		{key: "log_format", env: "LOG_FORMAT", usage: "text or json",
+			value: stringValue{&p.LogFormat}},
+		{key: "log_user_names", env: "LOG_USER_NAMES", usage: "log user names instead of redacting them",
+			value: boolValue{&p.LogUserNames}},
+		{key: "redis_url", env: "REDIS_URL", usage: "Redis URL",
+			value: stringValue{&p.RedisUrl}},
+		{key: "redis_timeout", env: "REDIS_TIMEOUT", usage: "how long a single Redis operation may take",
+			value: durationValue{&p.RedisTimeout}},
+		{key: "redis_dial_timeout", env: "REDIS_DIAL_TIMEOUT", usage: "how long connecting to Redis may take",
+			value: durationValue{&p.RedisDialTimeout}},
+		{key: "redis_username", env: "REDIS_USERNAME", usage: "Redis ACL user",
+			value: stringValue{&p.RedisUsername}},
+		{key: "redis_password", env: "REDIS_PASSWORD", usage: "Redis password", secret: true,
+			value: stringValue{&p.RedisPassword}},
+		{key: "redis_tls_ca_file", env: "REDIS_TLS_CA_FILE", usage: "CA certificate to verify a rediss:// server with",
+			value: stringValue{&p.RedisTlsCaFile}},
+		{key: "redis_sentinel_addrs", env: "REDIS_SENTINEL_ADDRS", usage: "comma-separated Sentinel host:port list",
+			value: listValue{&p.RedisSentinelAddrs}},
+		{key: "redis_sentinel_master", env: "REDIS_SENTINEL_MASTER", usage: "Sentinel master name",
+			value: stringValue{&p.RedisSentinelMaster}},
+		{key: "redis_sentinel_password", env: "REDIS_SENTINEL_PASSWORD", usage: "Sentinel password", secret: true,
+			value: stringValue{&p.RedisSentinelPassword}},
+		{key: "redis_pool_max_idle", env: "REDIS_POOL_MAX_IDLE", usage: "idle Redis connections to keep",
+			value: intValue{&p.RedisPoolMaxIdle}},
+		{key: "redis_pool_max_active", env: "REDIS_POOL_MAX_ACTIVE", usage: "Redis connection limit, 0 for none",
+			value: intValue{&p.RedisPoolMaxActive}},
+		{key: "redis_pool_idle_timeout", env: "REDIS_POOL_IDLE_TIMEOUT", usage: "how long an idle Redis connection is kept",
+			value: durationValue{&p.RedisPoolIdleTimeout}},
+		{key: "slash_signing_secret", env: "SLASH_SIGNING_SECRET", usage: "Slack signing secret", secret: true,
+			value: stringValue{&p.SlashSigningSecret}},
+		{key: "slash_token", env: "SLASH_TOKEN", usage: "Mattermost verification token", secret: true,
+			value: stringValue{&p.SlashToken}},
+		{key: "github_api_url", env: "GITHUB_API_URL", usage: "GitHub REST API URL, for sessions connected to GitHub Issues",
+			value: stringValue{&p.GithubApiUrl}},
+		{key: "features.slash_commands", env: "FEATURE_SLASH_COMMANDS", usage: "serve slash commands",
+			value: boolValue{&p.Features.SlashCommands}},
+		{key: "features.ui", env: "FEATURE_UI", usage: "serve the UI",
+			value: boolValue{&p.Features.Ui}},
+		{key: "features.metrics", env: "FEATURE_METRICS", usage: "serve Prometheus metrics at /metrics",
+			value: boolValue{&p.Features.Metrics}},
+	}
+}
+
+func defaults() Config {
+	return Config{
+		Environment:          DEV,
+		HttpPort:             ":8080",
+		SessionTtl:           48 * time.Hour,
+		SessionExpiryWarning: 5 * time.Minute,
+		SessionSweepInterval: 30 * time.Second,
+		Broker:               BrokerRedis,
+		GithubApiUrl:         "https://api.github.com",
+		LogLevel:             "info",
+		LogFormat:            "text",
+		RedisUrl:             "redis://localhost:6380",
+		RedisTimeout:         2 * time.Second,
+		RedisDialTimeout:     5 * time.Second,
+		RedisPoolMaxIdle:     3,
+		RedisPoolIdleTimeout: 120 * time.Second,
+		Features: Features{
+			SlashCommands: true,
+			Ui:            true,
+			Metrics:       true,
+		},
+	}
+}
+
+// Load resolves the configuration from the defaults, the config file, the environment and
+// the command-line arguments, and validates it
+func Load(args []string) (Config, error) {
+	config := defaults()
+	settings := config.settings()
+
+	flags := flag.NewFlagSet("ballot", flag.ContinueOnError)
+	flags.StringVar(&config.ConfigFile, "config", os.Getenv("BALLOT_CONFIG"), "YAML config `file` (env BALLOT_CONFIG)")
+	flags.BoolVar(&config.PrintConfig, "print-config", false, "print the resolved configuration, with secrets redacted, and exit")
+
+	// flags win over everything else, so they are only collected here and applied last
+	fromFlags := make(map[string]string)
+	for _, s := range settings {
+		_, isBool := s.value.(boolValue)
+		flags.Var(flagValue{key: s.key, values: fromFlags, isBool: isBool}, s.flagName(),
+			fmt.Sprintf("%s (env %s)", s.usage, s.env))
+	}
+
+	err := flags.Parse(args)
+	if err != nil {
+		return Config{}, err
+	}
+	config.Command = flags.Args()
+
+	var errs []error
+
+	if config.ConfigFile != "" {
+		err = loadFile(config.ConfigFile, settings)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	// an empty variable counts as not set, same as it always has
+	for _, s := range settings {
+		val := os.Getenv(s.env)
+		if val == "" {
+			continue
+		}
+		err = s.value.Set(val)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", s.env, err))
+		}
+	}
+
+	for _, s := range settings {
+		val, ok := fromFlags[s.key]
+		if !ok {
+			continue
+		}
+		err = s.value.Set(val)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("--%s: %w", s.flagName(), err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return Config{}, errors.Join(errs...)
+	}
+
+	if config.HttpHost == "" {
+		config.HttpHost = "http://localhost" + config.HttpPort
+	}
+	// the UI dev server runs on its own port
+	if config.AllowedOrigins == nil && config.Environment == DEV {
+		config.AllowedOrigins = []string{"*"}
+	}
+
+	return config, config.Validate()
+}
+
+// LoadConfig is Load without command-line arguments, for when Ballot is mounted in another program
+func LoadConfig() Config {
+	config, err := Load(nil)
+	if err != nil {
+		panic(fmt.Sprintf("Invalid configuration: %v", err))
+	}
+	return config
+}
+
+func loadFile(path string, settings []setting) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var doc map[string]interface{}
+	err = yaml.Unmarshal(data, &doc)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	values := make(map[string]interface{})
+	flatten("", doc, values)
+
+	var errs []error
+	for _, s := range settings {
+		val, ok := values[s.key]
+		if !ok {
+			continue
+		}
+		delete(values, s.key)
+
+		err = s.value.Set(fileValue(val))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %s: %w", path, s.key, err))
+		}
+	}
+
+	// most likely a typo, which would otherwise quietly leave the default in place
+	unknown := make([]string, 0, len(values))
+	for key := range values {
+		unknown = append(unknown, key)
+	}
+	sort.Strings(unknown)
+	for _, key := range unknown {
+		errs = append(errs, fmt.Errorf("%s: unknown setting %q", path, key))
+	}
+
+	return errors.Join(errs...)
+}
+
+func flatten(prefix string, doc map[string]interface{}, values map[string]interface{}) {
+	for key, val := range doc {
+		if nested, ok := val.(map[string]interface{}); ok {
+			flatten(prefix+key+".", nested, values)
+			continue
+		}
+		values[prefix+key] = val
+	}
+}
+
+func fileValue(val interface{}) string {
+	switch v := val.(type) {
+	case nil:
+		return ""
+	case []interface{}:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			items = append(items, fmt.Sprint(item))
+		}
+		return strings.Join(items, ",")
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// Print writes the configuration the way a config file would have it, with secrets redacted
+func (c Config) Print(w io.Writer) error {
+	doc := &yaml.Node{Kind: yaml.MappingNode}
+
+	for _, s := range c.settings() {
+		parts := strings.Split(s.key, ".")
+		parent := doc
+		for _, part := range parts[:len(parts)-1] {
+			parent = childMapping(parent, part)
+		}
+
+		parent.Content = append(parent.Content,
+			&yaml.Node{Kind: yaml.ScalarNode, Value: parts[len(parts)-1]},
+			printedValue(s))
+	}
+
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	err := encoder.Encode(doc)
+	if err != nil {
+		return err
+	}
+	return encoder.Close()
+}
+
+func childMapping(parent *yaml.Node, key string) *yaml.Node {
+	for i := 0; i < len(parent.Content); i += 2 {
+		if parent.Content[i].Value == key {
+			return parent.Content[i+1]
+		}
+	}
+
+	child := &yaml.Node{Kind: yaml.MappingNode}
+	parent.Content = append(parent.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, child)
+	return child
+}
+
+func printedValue(s setting) *yaml.Node {
+	switch v := s.value.(type) {
+	case listValue:
+		list := &yaml.Node{Kind: yaml.SequenceNode, Style: yaml.FlowStyle}
+		for _, item := range *v.p {
+			list.Content = append(list.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: item})
+		}
+		return list
+	case boolValue:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!bool", Value: v.String()}
+	case intValue:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!int", Value: v.String()}
+	}
+
+	val := s.value.String()
+	if s.secret && val != "" {
+		val = "REDACTED"
+	}
+	if s.key == "redis_url" {
+		val = redactUrl(val)
+	}
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: val}
+}
+
+// redactUrl hides the password in a URL, leaving the rest readable
+func redactUrl(rawUrl string) string {
+	u, err := url.Parse(rawUrl)
+	if err != nil || u.User == nil {
+		return rawUrl
+	}
+	if _, hasPassword := u.User.Password(); hasPassword {
+		u.User = url.UserPassword(u.User.Username(), "REDACTED")
+	}
+	return u.String()
+}
+
+// flagValue holds on to a flag until the other layers are in
+type flagValue struct {
+	key    string
+	values map[string]string
+	isBool bool
+}
+
+func (v flagValue) Set(s string) error {
+	v.values[v.key] = s
+	return nil
+}
+
+func (v flagValue) String() string {
+	return v.values[v.key]
+}
+
+func (v flagValue) IsBoolFlag() bool {
+	return v.isBool
+}
+
+type stringValue struct{ p *string }
+
+func (v stringValue) Set(s string) error {
+	*v.p = s
+	return nil
+}
+
+func (v stringValue) String() string {
+	if v.p == nil {
+		return ""
+	}
+	return *v.p
+}
+
+// portValue is a bare port number everywhere but in the Config, which has it as a listen address
+type portValue struct{ p *string }
+
+func (v portValue) Set(s string) error {
+	*v.p = ":" + strings.TrimPrefix(s, ":")
+	return nil
+}
+
+func (v portValue) String() string {
+	if v.p == nil {
+		return ""
+	}
+	return strings.TrimPrefix(*v.p, ":")
+}
+
+type intValue struct{ p *int }
+
+func (v intValue) Set(s string) error {
+	num, err := strconv.Atoi(s)
+	if err != nil {
+		return fmt.Errorf("%q is not a number", s)
+	}
+	*v.p = num
+	return nil
+}
+
+func (v intValue) String() string {
+	if v.p == nil {
+		return ""
+	}
+	return strconv.Itoa(*v.p)
+}
+
+type durationValue struct{ p *time.Duration }
+
+func (v durationValue) Set(s string) error {
+	duration, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*v.p = duration
+	return nil
+}
+
+func (v durationValue) String() string {
+	if v.p == nil {
+		return ""
+	}
+	return v.p.String()
+}
+
+type boolValue struct{ p *bool }
+
+func (v boolValue) Set(s string) error {
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		return fmt.Errorf("%q is not true or false", s)
+	}
+	*v.p = b
+	return nil
+}
+
+func (v boolValue) String() string {
+	if v.p == nil {
+		return ""
+	}
+	return strconv.FormatBool(*v.p)
+}
+
+// listValue is comma-separated. An empty value is an empty list, rather than no value.
+type listValue struct{ p *[]string }
+
+func (v listValue) Set(s string) error {
+	list := make([]string, 0)
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			list = append(list, item)
+		}
+	}
+	*v.p = list
+	return nil
+}
+
+func (v listValue) String() string {
+	if v.p == nil {
+		return ""
+	}
+	return strings.Join(*v.p, ",")
+}
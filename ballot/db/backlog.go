@@ -0,0 +1,66 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gomodule/redigo/redis"
+	"github.com/joomcode/errorx"
+	"github.com/papito/ballot/ballot/model"
+)
+
+/* The backlog is a list of the stories still to be estimated, as JSON. The story at the head of the list is
+estimated when a vote starts, and it comes off the list when the vote finishes. The story of the last vote is
+also kept on its own, so that stories added in the middle of a vote do not get mixed up with it.
+*/
+
+// QueueStories adds the stories to the end of the backlog
+func (b *Batch) QueueStories(sessionId string, stories []model.Story) (*Batch, error) {
+	if len(stories) == 0 {
+		return b, nil
+	}
+
+	vals := make([]interface{}, 0, len(stories))
+	for _, story := range stories {
+		data, err := json.Marshal(story)
+		if err != nil {
+			return b, errorx.EnsureStackTrace(err)
+		}
+		vals = append(vals, data)
+	}
+	return b.Push(fmt.Sprintf(Const.Backlog, sessionId), vals...), nil
+}
+
+// GetBacklog is the stories still to be estimated, in order
+func (p *Store) GetBacklog(ctx context.Context, sessionId string) ([]model.Story, error) {
+	vals, err := redis.ByteSlices(p.do(ctx, "LRANGE", fmt.Sprintf(Const.Backlog, sessionId), 0, -1))
+	if err != nil {
+		return nil, errorx.EnsureStackTrace(err)
+	}
+
+	stories := make([]model.Story, len(vals))
+	for idx, val := range vals {
+		err = json.Unmarshal(val, &stories[idx])
+		if err != nil {
+			return nil, errorx.EnsureStackTrace(err)
+		}
+	}
+	return stories, nil
+}
+
+// NextStory is the story at the head of the backlog, if there is one, as it is stored
+func (p *Store) NextStory(ctx context.Context, sessionId string) ([]byte, error) {
+	val, err := redis.Bytes(p.do(ctx, "LINDEX", fmt.Sprintf(Const.Backlog, sessionId), 0))
+	if err == redis.ErrNil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errorx.EnsureStackTrace(err)
+	}
+	return val, nil
+}
+
+// EstimatedStory takes the story off the backlog, once its vote is over
+func (b *Batch) EstimatedStory(sessionId string, stored []byte) *Batch {
+	return b.add("LREM", fmt.Sprintf(Const.Backlog, sessionId), 1, stored)
+}
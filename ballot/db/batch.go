@@ -0,0 +1,108 @@
+package db
+
+import (
+	"context"
+	"github.com/gomodule/redigo/redis"
+	"github.com/joomcode/errorx"
+	"time"
+)
+
+/* Writes that belong together are queued in a Batch and sent to Redis in one MULTI/EXEC
+transaction - a single round trip, no matter how many keys are touched. Every key written
+through a batch gets the store session TTL, same as the single-key store methods.
+*/
+
+type command struct {
+	name string
+	args []interface{}
+}
+
+type Batch struct {
+	commands []command
+	ttl      int
+}
+
+// A store without a session TTL, as in a test, still should not write keys that never expire
+const defaultSessionTtl = 48 * time.Hour
+
+// NewBatch starts a batch that writes keys with the store session TTL
+func (p *Store) NewBatch() *Batch {
+	return &Batch{ttl: p.ttl()}
+}
+
+func (b *Batch) add(name string, args ...interface{}) *Batch {
+	b.commands = append(b.commands, command{name: name, args: args})
+	return b
+}
+
+func (b *Batch) expire(key string) *Batch {
+	return b.add("EXPIRE", key, b.ttl)
+}
+
+func (b *Batch) Len() int {
+	return len(b.commands)
+}
+
+func (b *Batch) Set(key string, val interface{}) *Batch {
+	return b.add("SET", key, val, "EX", b.ttl)
+}
+
+func (b *Batch) SetHashKey(key string, args ...interface{}) *Batch {
+	return b.add("HSET", append([]interface{}{key}, args...)...).expire(key)
+}
+
+func (b *Batch) AddToSet(key string, args ...interface{}) *Batch {
+	return b.add("SADD", append([]interface{}{key}, args...)...).expire(key)
+}
+
+func (b *Batch) Push(key string, args ...interface{}) *Batch {
+	return b.add("RPUSH", append([]interface{}{key}, args...)...).expire(key)
+}
+
+func (b *Batch) Del(keys ...interface{}) *Batch {
+	return b.add("DEL", keys...)
+}
+
+func (b *Batch) Incr(key string, num uint8) *Batch {
+	return b.add("INCRBY", key, num)
+}
+
+// Exec sends the batch as one transaction
+func (p *Store) Exec(ctx context.Context, b *Batch) error {
+	if b.Len() == 0 {
+		return nil
+	}
+	_, err := p.exec(ctx, b)
+	return err
+}
+
+// exec sends the batch as one transaction, and is the replies to its commands
+func (p *Store) exec(ctx context.Context, b *Batch) ([]interface{}, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
+	c, err := p.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer p.Close(c)
+
+	_ = c.Send("MULTI")
+	for _, cmd := range b.commands {
+		_ = c.Send(cmd.name, cmd.args...)
+	}
+
+	// a command Redis refused to queue fails the whole EXEC, and comes back as the error here
+	replies, err := redis.Values(p.roundTrip(ctx, c, "EXEC"))
+	if err != nil {
+		return nil, errorx.EnsureStackTrace(err)
+	}
+
+	for _, reply := range replies {
+		if redisErr, ok := reply.(redis.Error); ok {
+			redisErrors.WithLabelValues("EXEC", "reply").Inc()
+			return nil, errorx.Decorate(redisErr, "Redis error")
+		}
+	}
+	return replies, nil
+}
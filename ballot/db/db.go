@@ -1,89 +1,208 @@
 package db
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/gomodule/redigo/redis"
 	"github.com/joomcode/errorx"
-	"github.com/papito/ballot/ballot/config"
+	"github.com/papito/ballot/ballot/logutil"
 	"github.com/papito/ballot/ballot/model"
-	"log"
+	"log/slog"
+	"net"
 	"sort"
 	"strconv"
+	"sync/atomic"
 	"time"
 )
 
 type Store struct {
 	Pool          *redis.Pool
-	SubConn       redis.PubSubConn
-	ServiceSubCon redis.PubSubConn
+	SubConn       PubSub
+	ServiceSubCon PubSub
 	redisUrl      string
+
+	// How long a single store operation may take, on top of any deadline the caller already has
+	Timeout time.Duration
+
+	// Every session key expires after this long without a write
+	SessionTtl time.Duration
+
+	// every time the store waited on Redis
+	roundTrips atomic.Int64
 }
 
 var Const = struct {
 	SessionState     string
 	SessionUsers     string
 	SessionObservers string
+	SessionMembers   string
 	User             string
 	VoteCount        string
 	Tally            string
+	Title            string
+	ResponseUrl      string
+	ExpiryWarned     string
+	Expiry           string
+	Rounds           string
+	RoundStarted     string
+	Backlog          string
+	Story            string
+	Tracker          string
+	Estimate         string
 }{
 	"ballot:session:%s:voting",
 	"ballot:session:%s:users",
 	"ballot:session:%s:observers",
+	"ballot:session:%s:members",
 	"ballot:user:%s",
 	"ballot:session:%s:vote_count",
 	"ballot:session:%s:tally",
+	"ballot:session:%s:title",
+	"ballot:session:%s:response_url",
+	"ballot:session:%s:expiry_warned",
+	"ballot:expiry",
+	"ballot:session:%s:rounds",
+	"ballot:session:%s:round_started",
+	"ballot:session:%s:backlog",
+	"ballot:session:%s:story",
+	"ballot:session:%s:tracker",
+	"ballot:session:%s:estimate",
 }
 
-func NewPool(server string) *redis.Pool {
-	return &redis.Pool{
-		MaxIdle:     3,
-		IdleTimeout: 120 * time.Second,
-		Dial: func() (redis.Conn, error) {
-			c, err := redis.DialURL(server)
-			if err != nil {
-				return nil, errorx.EnsureStackTrace(err)
-			}
-			return c, err
-		},
-		TestOnBorrow: func(c redis.Conn, t time.Time) error {
-			_, err := c.Do("PING")
-			return err
-		},
+// SessionKeys are all the keys of a session that expire together, not counting its users
+func SessionKeys(sessionId string) []string {
+	return []string{
+		fmt.Sprintf(Const.SessionState, sessionId),
+		fmt.Sprintf(Const.SessionUsers, sessionId),
+		fmt.Sprintf(Const.SessionObservers, sessionId),
+		fmt.Sprintf(Const.SessionMembers, sessionId),
+		fmt.Sprintf(Const.VoteCount, sessionId),
+		fmt.Sprintf(Const.Tally, sessionId),
+		fmt.Sprintf(Const.Title, sessionId),
+		fmt.Sprintf(Const.ResponseUrl, sessionId),
+		fmt.Sprintf(Const.Rounds, sessionId),
+		fmt.Sprintf(Const.RoundStarted, sessionId),
+		fmt.Sprintf(Const.Backlog, sessionId),
+		fmt.Sprintf(Const.Story, sessionId),
+		fmt.Sprintf(Const.Tracker, sessionId),
+		fmt.Sprintf(Const.Estimate, sessionId),
+	}
+}
+
+// IsNotFound is true when a read failed only because the key does not exist
+func IsNotFound(err error) bool {
+	return errors.Is(err, redis.ErrNil)
+}
+
+// IsTimeout is true when Redis did not answer in time
+func IsTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
 	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// IsUnavailable is true when Redis could not be reached at all
+func IsUnavailable(err error) bool {
+	if errors.Is(err, redis.ErrPoolExhausted) {
+		return true
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
 }
 
 func (p *Store) Close(c redis.Conn) {
 	err := c.Close()
 	if err != nil {
-		fmt.Printf("Error closing connection: %+v", errorx.EnsureStackTrace(err))
+		slog.Warn("Could not close a Redis connection", logutil.Err(err))
 	}
 }
 
-func (p *Store) ExpireKey(key string) error {
-	_, err := p.Pool.Get().Do("EXPIRE", key, config.SessionTtl)
+// ttl is the session TTL in seconds, as Redis wants it
+func (p *Store) ttl() int {
+	if p.SessionTtl <= 0 {
+		return int(defaultSessionTtl.Seconds())
+	}
+	return int(p.SessionTtl.Seconds())
+}
+
+// withTimeout bounds a single store operation by the store timeout
+func (p *Store) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if p.Timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, p.Timeout)
+}
+
+// conn takes a connection from the pool. Failing to get one is counted as a Redis error.
+func (p *Store) conn(ctx context.Context) (redis.Conn, error) {
+	c, err := p.Pool.GetContext(ctx)
 	if err != nil {
-		return errorx.EnsureStackTrace(err)
+		redisErrors.WithLabelValues("CONNECT", errorKind(err)).Inc()
+		return nil, errorx.EnsureStackTrace(err)
 	}
-	return nil
+	return c, nil
 }
 
-func (p *Store) Set(key string, val interface{}) error {
-	_, err := p.Pool.Get().Do("SET", key, val)
+// do runs one command on a pooled connection, and gives the connection back when done
+func (p *Store) do(ctx context.Context, cmd string, args ...interface{}) (interface{}, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
+	c, err := p.conn(ctx)
 	if err != nil {
-		return errorx.EnsureStackTrace(err)
+		return nil, err
 	}
+	defer p.Close(c)
+
+	reply, err := p.roundTrip(ctx, c, cmd, args...)
+	if err != nil {
+		return nil, errorx.EnsureStackTrace(err)
+	}
+	return reply, nil
+}
+
+// roundTrip flushes anything sent on the connection along with the command, and waits for all the replies
+func (p *Store) roundTrip(ctx context.Context, c redis.Conn, cmd string, args ...interface{}) (interface{}, error) {
+	p.roundTrips.Add(1)
+	start := time.Now()
+	reply, err := redis.DoContext(c, ctx, cmd, args...)
+	observe(cmd, start, err)
+	return reply, err
+}
 
-	err = p.ExpireKey(key)
+// RoundTrips is how many times the store has waited on Redis so far
+func (p *Store) RoundTrips() int64 {
+	return p.roundTrips.Load()
+}
+
+// Ping checks that Redis answers
+func (p *Store) Ping(ctx context.Context) error {
+	_, err := p.do(ctx, "PING")
+	return err
+}
+
+func (p *Store) ExpireKey(ctx context.Context, key string) error {
+	_, err := p.do(ctx, "EXPIRE", key, p.ttl())
 	if err != nil {
 		return err
 	}
+	return nil
+}
 
+func (p *Store) Set(ctx context.Context, key string, val interface{}) error {
+	_, err := p.do(ctx, "SET", key, val, "EX", p.ttl())
+	if err != nil {
+		return err
+	}
 	return nil
 }
 
-func (p *Store) GetSetLength(key string) (int, error) {
-	size, err := redis.Int(p.Pool.Get().Do("SCARD", key))
+func (p *Store) GetSetLength(ctx context.Context, key string) (int, error) {
+	size, err := redis.Int(p.do(ctx, "SCARD", key))
 	if err != nil {
 		return 0, errorx.EnsureStackTrace(err)
 	}
@@ -91,101 +210,78 @@ func (p *Store) GetSetLength(key string) (int, error) {
 	return size, nil
 }
 
-func (p *Store) Del(key string) error {
-	c := p.Pool.Get()
-	defer p.Close(c)
-	_, err := c.Do("DEL", key)
+func (p *Store) Del(ctx context.Context, key string) error {
+	_, err := p.do(ctx, "DEL", key)
 	if err != nil {
-		return errorx.EnsureStackTrace(err)
+		return err
 	}
 	return nil
 }
 
-func (p *Store) Incr(key string, num uint8) error {
-	c := p.Pool.Get()
-	defer p.Close(c)
-	_, err := c.Do("INCRBY", key, num)
+func (p *Store) Incr(ctx context.Context, key string, num uint8) error {
+	_, err := p.do(ctx, "INCRBY", key, num)
 	if err != nil {
-		return errorx.EnsureStackTrace(err)
+		return err
 	}
 	return nil
 }
 
-func (p *Store) Decr(key string, num uint8) error {
-	c := p.Pool.Get()
-	defer p.Close(c)
-	_, err := c.Do("DECRBY", key, num)
+func (p *Store) Decr(ctx context.Context, key string, num uint8) error {
+	_, err := p.do(ctx, "DECRBY", key, num)
 	if err != nil {
-		return errorx.EnsureStackTrace(err)
+		return err
 	}
 	return nil
 }
 
-func (p *Store) GetInt(key string) (int, error) {
-	c := p.Pool.Get()
-	defer p.Close(c)
-	val, err := redis.Int(c.Do("GET", key))
+func (p *Store) GetInt(ctx context.Context, key string) (int, error) {
+	val, err := redis.Int(p.do(ctx, "GET", key))
 	if err != nil {
 		return 0, errorx.EnsureStackTrace(err)
 	}
 	return val, nil
 }
 
-func (p *Store) GetStr(key string) (string, error) {
-	c := p.Pool.Get()
-	defer p.Close(c)
-	val, err := redis.String(c.Do("GET", key))
+func (p *Store) GetStr(ctx context.Context, key string) (string, error) {
+	val, err := redis.String(p.do(ctx, "GET", key))
 	if err != nil {
 		return "", errorx.EnsureStackTrace(err)
 	}
 	return val, nil
 }
 
-func (p *Store) SetHashKey(key string, args ...interface{}) error {
-	// combine the key and the args into a list of interfaces
-	redisArgs := []interface{}{key}
-	redisArgs = append(redisArgs, args...)
-	c := p.Pool.Get()
-	defer p.Close(c)
-	_, err := c.Do("HSET", redisArgs[:]...)
+// GetStrs reads several keys at once. Keys that do not exist come back as empty strings.
+func (p *Store) GetStrs(ctx context.Context, keys ...interface{}) ([]string, error) {
+	vals, err := redis.Strings(p.do(ctx, "MGET", keys...))
 	if err != nil {
-		return errorx.EnsureStackTrace(err)
-	}
-
-	err = p.ExpireKey(key)
-	if err != nil {
-		return err
+		return nil, errorx.EnsureStackTrace(err)
 	}
+	return vals, nil
+}
 
-	return nil
+func (p *Store) SetHashKey(ctx context.Context, key string, args ...interface{}) error {
+	return p.Exec(ctx, p.NewBatch().SetHashKey(key, args...))
 }
 
-func (p *Store) DelHashKey(field string) error {
-	c := p.Pool.Get()
-	defer p.Close(c)
-	_, err := c.Do("HDEL", field)
+func (p *Store) DelHashKey(ctx context.Context, key string, fields ...interface{}) error {
+	_, err := p.do(ctx, "HDEL", append([]interface{}{key}, fields...)...)
 	if err != nil {
-		return errorx.EnsureStackTrace(err)
+		return err
 	}
 	return nil
 }
 
-func (p *Store) GetHashKey(key string, field string) (string, error) {
-	c := p.Pool.Get()
-	defer p.Close(c)
-	val, err := redis.String(c.Do("HGET", key, field))
+func (p *Store) GetHashKey(ctx context.Context, key string, field string) (string, error) {
+	val, err := redis.String(p.do(ctx, "HGET", key, field))
 	if err != nil {
-		log.Println(err)
 		return "", err
 	}
 	return val, nil
 }
 
-func (p *Store) GetSessionVoterIds(sessionId string) ([]string, error) {
-	c := p.Pool.Get()
-	defer p.Close(c)
+func (p *Store) GetSessionVoterIds(ctx context.Context, sessionId string) ([]string, error) {
 	key := fmt.Sprintf(Const.SessionUsers, sessionId)
-	userIds, err := redis.Strings(c.Do("SMEMBERS", key))
+	userIds, err := redis.Strings(p.do(ctx, "SMEMBERS", key))
 	if err != nil {
 		return make([]string, 0), errorx.EnsureStackTrace(err)
 	}
@@ -193,11 +289,9 @@ func (p *Store) GetSessionVoterIds(sessionId string) ([]string, error) {
 	return userIds, nil
 }
 
-func (p *Store) GetSessionObserverIds(sessionId string) ([]string, error) {
-	c := p.Pool.Get()
-	defer p.Close(c)
+func (p *Store) GetSessionObserverIds(ctx context.Context, sessionId string) ([]string, error) {
 	key := fmt.Sprintf(Const.SessionObservers, sessionId)
-	userIds, err := redis.Strings(c.Do("SMEMBERS", key))
+	userIds, err := redis.Strings(p.do(ctx, "SMEMBERS", key))
 	if err != nil {
 		return make([]string, 0), errorx.EnsureStackTrace(err)
 	}
@@ -205,76 +299,180 @@ func (p *Store) GetSessionObserverIds(sessionId string) ([]string, error) {
 	return userIds, nil
 }
 
-func (p *Store) AddToSet(key string, args ...interface{}) error {
-	// combine the key and the args into a list of interfaces
-	redisArgs := []interface{}{key}
-	redisArgs = append(redisArgs, args...)
-	c := p.Pool.Get()
-	defer p.Close(c)
-	_, err := c.Do("SADD", redisArgs[:]...)
+func (p *Store) AddToSet(ctx context.Context, key string, args ...interface{}) error {
+	return p.Exec(ctx, p.NewBatch().AddToSet(key, args...))
+}
+
+func (p *Store) RemoveFromSet(ctx context.Context, key string, val string) error {
+	_, err := p.do(ctx, "SREM", key, val)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (p *Store) IsMember(ctx context.Context, key string, val string) (bool, error) {
+	isMember, err := redis.Bool(p.do(ctx, "SISMEMBER", key, val))
 	if err != nil {
-		return errorx.EnsureStackTrace(err)
+		return false, errorx.EnsureStackTrace(err)
 	}
+	return isMember, nil
+}
 
-	err = p.ExpireKey(key)
+// sessionUserIds are everyone who joined the session. Sessions from before the members set was kept only have
+// their users in the sets of voters and observers.
+func (p *Store) sessionUserIds(ctx context.Context, sessionId string) ([]string, error) {
+	userIds, err := redis.Strings(p.do(ctx, "SUNION",
+		fmt.Sprintf(Const.SessionMembers, sessionId),
+		fmt.Sprintf(Const.SessionUsers, sessionId),
+		fmt.Sprintf(Const.SessionObservers, sessionId)))
+	if err != nil {
+		return nil, errorx.EnsureStackTrace(err)
+	}
+	return userIds, nil
+}
+
+// DeleteSession deletes every key of the session and of its users, in one transaction
+func (p *Store) DeleteSession(ctx context.Context, sessionId string) error {
+	userIds, err := p.sessionUserIds(ctx, sessionId)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	batch := p.NewBatch().deleteSession(sessionId, userIds).add("ZREM", Const.Expiry, sessionId)
+	return p.Exec(ctx, batch)
 }
 
-func (p *Store) RemoveFromSet(key string, val string) error {
-	c := p.Pool.Get()
-	defer p.Close(c)
-	_, err := c.Do("SREM", key, val)
+// deleteSession deletes the keys of a session, and of the given users
+func (b *Batch) deleteSession(sessionId string, userIds []string) *Batch {
+	var keys []interface{}
+	for _, key := range SessionKeys(sessionId) {
+		keys = append(keys, key)
+	}
+	for _, userId := range userIds {
+		keys = append(keys, fmt.Sprintf(Const.User, userId))
+	}
+	keys = append(keys, fmt.Sprintf(Const.ExpiryWarned, sessionId))
+	return b.Del(keys...)
+}
+
+func (p *Store) Publish(ctx context.Context, channel string, data string) error {
+	_, err := p.do(ctx, "PUBLISH", channel, data)
 	if err != nil {
-		return errorx.EnsureStackTrace(err)
+		return err
 	}
 	return nil
 }
 
-func (p *Store) GetSessionVoters(sessionId string) ([]model.User, error) {
-	return p.GetUsers(sessionId, false)
+// GetSession reads the session snapshot, hiding the estimates while voting.
+// A session that does not exist, or has expired, is a not found error.
+func (p *Store) GetSession(ctx context.Context, sessionId string) (model.SessionSnapshot, error) {
+	vals, err := p.GetStrs(ctx,
+		fmt.Sprintf(Const.SessionState, sessionId),
+		fmt.Sprintf(Const.Tally, sessionId),
+		fmt.Sprintf(Const.Title, sessionId),
+		fmt.Sprintf(Const.Estimate, sessionId),
+		fmt.Sprintf(Const.Story, sessionId))
+	if err != nil {
+		return model.SessionSnapshot{}, err
+	}
+	if vals[0] == "" {
+		return model.SessionSnapshot{}, errorx.EnsureStackTrace(redis.ErrNil)
+	}
+
+	var story *model.Story
+	if vals[4] != "" {
+		story = &model.Story{}
+		err = json.Unmarshal([]byte(vals[4]), story)
+		if err != nil {
+			return model.SessionSnapshot{}, errorx.EnsureStackTrace(err)
+		}
+	}
+
+	state, err := strconv.Atoi(vals[0])
+	if err != nil {
+		return model.SessionSnapshot{}, errorx.EnsureStackTrace(err)
+	}
+
+	users, err := p.GetSessionVoters(ctx, sessionId)
+	if err != nil {
+		return model.SessionSnapshot{}, err
+	}
+	if state == model.Voting {
+		for idx := range users {
+			users[idx].Estimate = model.NoEstimate
+		}
+	}
+
+	observers, err := p.GetSessionObservers(ctx, sessionId)
+	if err != nil {
+		return model.SessionSnapshot{}, err
+	}
+
+	return model.SessionSnapshot{
+		SessionId:    sessionId,
+		SessionState: state,
+		Title:        vals[2],
+		Users:        users,
+		Observers:    observers,
+		Tally:        vals[1],
+		Estimate:     vals[3],
+		Story:        story,
+	}, nil
+}
+
+func (p *Store) GetSessionVoters(ctx context.Context, sessionId string) ([]model.User, error) {
+	return p.GetUsers(ctx, sessionId, false)
 }
 
-func (p *Store) GetSessionObservers(sessionId string) ([]model.User, error) {
-	return p.GetUsers(sessionId, true)
+func (p *Store) GetSessionObservers(ctx context.Context, sessionId string) ([]model.User, error) {
+	return p.GetUsers(ctx, sessionId, true)
 }
 
-func (p *Store) GetUsers(sessionId string, isObserver bool) ([]model.User, error) {
+func (p *Store) GetUsers(ctx context.Context, sessionId string, isObserver bool) ([]model.User, error) {
 	var userIds = make([]string, 0)
 	var err error
 
 	if isObserver {
-		userIds, err = p.GetSessionObserverIds(sessionId)
+		userIds, err = p.GetSessionObserverIds(ctx, sessionId)
 		if err != nil {
 			return make([]model.User, 0), errorx.EnsureStackTrace(err)
 		}
 
 	} else {
-		userIds, err = p.GetSessionVoterIds(sessionId)
+		userIds, err = p.GetSessionVoterIds(ctx, sessionId)
 		if err != nil {
 			return make([]model.User, 0), errorx.EnsureStackTrace(err)
 		}
 
 	}
 
-	log.Printf("Session users for [%s]: %s", sessionId, userIds)
+	slog.DebugContext(ctx, "Session users", logutil.SessionIdKey, sessionId, "user_ids", userIds)
 
-	c := p.Pool.Get()
-	defer p.Close(c)
+	return p.GetUsersById(ctx, userIds)
+}
 
+// GetUsersById fetches all the users in one round trip, sorted by when they joined
+func (p *Store) GetUsersById(ctx context.Context, userIds []string) ([]model.User, error) {
 	if len(userIds) == 0 {
 		return make([]model.User, 0), nil
 	}
 
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
+	c, err := p.conn(ctx)
+	if err != nil {
+		return make([]model.User, 0), err
+	}
+	defer p.Close(c)
+
 	for _, userId := range userIds {
 		key := fmt.Sprintf(Const.User, userId)
 		_ = c.Send("HGETALL", key)
 	}
 
-	res, err := redis.Values(c.Do(""))
+	res, err := redis.Values(p.roundTrip(ctx, c, ""))
 	if err != nil {
 		return make([]model.User, 0), errorx.EnsureStackTrace(err)
 	}
@@ -315,12 +513,10 @@ func (p *Store) GetUsers(sessionId string, isObserver bool) ([]model.User, error
 	return users, nil
 }
 
-func (p *Store) GetUser(userId string) (model.User, error) {
+func (p *Store) GetUser(ctx context.Context, userId string) (model.User, error) {
 	key := fmt.Sprintf(Const.User, userId)
-	c := p.Pool.Get()
-	defer p.Close(c)
 
-	resp, err := c.Do("HGETALL", key)
+	resp, err := p.do(ctx, "HGETALL", key)
 	if err != nil {
 		return model.User{}, errorx.EnsureStackTrace(err)
 	}
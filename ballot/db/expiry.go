@@ -0,0 +1,95 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"github.com/gomodule/redigo/redis"
+	"github.com/joomcode/errorx"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/* Sessions expire after the session TTL without activity. Any activity slides the expiry of every key of the
+session, and of its users, forward together, so that a session never loses part of its data.
+
+The expiry index is a sorted set of session IDs, scored by when they expire, which the sweeper reads to warn
+sessions that are about to expire and to announce the ones that have. Any number of instances may sweep: a warning
+or an expiry is claimed in Redis first, so only one of them announces it.
+*/
+
+// TrackSession puts the session in the expiry index, due a session TTL from now
+func (b *Batch) TrackSession(sessionId string) *Batch {
+	return b.add("ZADD", Const.Expiry, time.Now().Unix()+int64(b.ttl), sessionId)
+}
+
+// touchUsers slides the expiry of every user of the session. The users are read in Redis, so that a touch can ride
+// along with any write, in the same round trip. KEYS are the sets of members, voters and observers, and ARGV the
+// prefix of the user keys and the TTL.
+const touchUsers = `
+for _, userId in ipairs(redis.call('SUNION', KEYS[1], KEYS[2], KEYS[3])) do
+	redis.call('EXPIRE', ARGV[1] .. userId, ARGV[2])
+end
+return 0`
+
+// Touch slides the expiry of the session and of its users forward, and clears any expiry warning already sent.
+// Keys that have not been written yet are left alone.
+func (b *Batch) Touch(sessionId string) *Batch {
+	for _, key := range SessionKeys(sessionId) {
+		b.expire(key)
+	}
+	b.add("EVAL", touchUsers, 3,
+		fmt.Sprintf(Const.SessionMembers, sessionId),
+		fmt.Sprintf(Const.SessionUsers, sessionId),
+		fmt.Sprintf(Const.SessionObservers, sessionId),
+		strings.TrimSuffix(Const.User, "%s"), b.ttl)
+	b.Del(fmt.Sprintf(Const.ExpiryWarned, sessionId))
+	return b.TrackSession(sessionId)
+}
+
+// TouchSession is a touch on its own, for activity that writes nothing, such as a socket watching the session
+func (p *Store) TouchSession(ctx context.Context, sessionId string) error {
+	return p.Exec(ctx, p.NewBatch().Touch(sessionId))
+}
+
+// ExpiringSessions are the sessions that expire by the given time, with when they expire
+func (p *Store) ExpiringSessions(ctx context.Context, by time.Time) (map[string]time.Time, error) {
+	vals, err := redis.Strings(p.do(ctx, "ZRANGEBYSCORE", Const.Expiry, "-inf", by.Unix(), "WITHSCORES"))
+	if err != nil {
+		return nil, errorx.EnsureStackTrace(err)
+	}
+
+	sessions := make(map[string]time.Time, len(vals)/2)
+	for i := 0; i+1 < len(vals); i += 2 {
+		expiresAt, err := strconv.ParseInt(vals[i+1], 10, 64)
+		if err != nil {
+			return nil, errorx.EnsureStackTrace(err)
+		}
+		sessions[vals[i]] = time.Unix(expiresAt, 0)
+	}
+	return sessions, nil
+}
+
+// ClaimExpiry takes the session out of the expiry index. It is true for the one caller that did.
+func (p *Store) ClaimExpiry(ctx context.Context, sessionId string) (bool, error) {
+	removed, err := redis.Int(p.do(ctx, "ZREM", Const.Expiry, sessionId))
+	if err != nil {
+		return false, errorx.EnsureStackTrace(err)
+	}
+	return removed == 1, nil
+}
+
+// ClaimExpiryWarning is true for the one caller that gets to warn that the session expires at the given time
+func (p *Store) ClaimExpiryWarning(ctx context.Context, sessionId string, expiresAt time.Time) (bool, error) {
+	ttl := int(time.Until(expiresAt).Seconds())
+	if ttl < 1 {
+		ttl = 1
+	}
+
+	reply, err := p.do(ctx, "SET", fmt.Sprintf(Const.ExpiryWarned, sessionId), expiresAt.Unix(), "NX", "EX", ttl)
+	if err != nil {
+		return false, errorx.EnsureStackTrace(err)
+	}
+	// not set, when it was already there
+	return reply != nil, nil
+}
@@ -0,0 +1,151 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"github.com/gomodule/redigo/redis"
+	"github.com/joomcode/errorx"
+	"sort"
+	"strings"
+)
+
+/* Keys that outlive what they belong to are garbage. They expire with the session TTL anyway, but until then
+they are counted: a user left in a session set after their hash is gone still counts towards a finished vote.
+
+FindGarbage scans the users before the sessions, so that a user created while it runs is never taken for an
+orphan - a user is put in the members set of its session in the same transaction that creates it.
+*/
+
+// Garbage is what FindGarbage found, sorted
+type Garbage struct {
+	// user IDs no live session refers to
+	OrphanedUsers []string
+	// sessions that have keys left, but no state
+	BrokenSessions []string
+	// session sets that hold users who do not exist
+	DanglingMembers []DanglingMember
+}
+
+type DanglingMember struct {
+	Key    string
+	UserId string
+}
+
+func (g Garbage) Len() int {
+	return len(g.OrphanedUsers) + len(g.BrokenSessions) + len(g.DanglingMembers)
+}
+
+// scan lists the keys that match a pattern, without blocking Redis the way KEYS would
+func (p *Store) scan(ctx context.Context, match string) ([]string, error) {
+	var keys []string
+	cursor := "0"
+	for {
+		vals, err := redis.Values(p.do(ctx, "SCAN", cursor, "MATCH", match, "COUNT", 1000))
+		if err != nil {
+			return nil, errorx.EnsureStackTrace(err)
+		}
+		cursor, _ = redis.String(vals[0], nil)
+		batch, _ := redis.Strings(vals[1], nil)
+		keys = append(keys, batch...)
+
+		if cursor == "0" {
+			return keys, nil
+		}
+	}
+}
+
+// FindGarbage looks through every key of every session and user, and reports the ones left behind
+func (p *Store) FindGarbage(ctx context.Context) (Garbage, error) {
+	garbage := Garbage{}
+
+	userKeys, err := p.scan(ctx, fmt.Sprintf(Const.User, "*"))
+	if err != nil {
+		return garbage, err
+	}
+	userPrefix := fmt.Sprintf(Const.User, "")
+	users := make(map[string]bool, len(userKeys))
+	for _, key := range userKeys {
+		users[strings.TrimPrefix(key, userPrefix)] = true
+	}
+
+	sessionKeys, err := p.scan(ctx, "ballot:session:*")
+	if err != nil {
+		return garbage, err
+	}
+	sessions := make(map[string]bool)
+	for _, key := range sessionKeys {
+		// ballot:session:{session_id}:{what}
+		parts := strings.Split(key, ":")
+		if len(parts) != 4 {
+			continue
+		}
+		sessionId := parts[2]
+		sessions[sessionId] = sessions[sessionId] || key == fmt.Sprintf(Const.SessionState, sessionId)
+	}
+
+	referenced := make(map[string]bool)
+	for sessionId, live := range sessions {
+		if !live {
+			garbage.BrokenSessions = append(garbage.BrokenSessions, sessionId)
+			continue
+		}
+
+		for _, set := range []string{Const.SessionUsers, Const.SessionObservers, Const.SessionMembers} {
+			key := fmt.Sprintf(set, sessionId)
+			userIds, err := redis.Strings(p.do(ctx, "SMEMBERS", key))
+			if err != nil {
+				return garbage, errorx.EnsureStackTrace(err)
+			}
+			for _, userId := range userIds {
+				referenced[userId] = true
+				if !users[userId] {
+					garbage.DanglingMembers = append(garbage.DanglingMembers, DanglingMember{Key: key, UserId: userId})
+				}
+			}
+		}
+	}
+
+	for userId := range users {
+		if !referenced[userId] {
+			garbage.OrphanedUsers = append(garbage.OrphanedUsers, userId)
+		}
+	}
+
+	sort.Strings(garbage.OrphanedUsers)
+	sort.Strings(garbage.BrokenSessions)
+	sort.Slice(garbage.DanglingMembers, func(i, j int) bool {
+		a, b := garbage.DanglingMembers[i], garbage.DanglingMembers[j]
+		return a.Key < b.Key || a.Key == b.Key && a.UserId < b.UserId
+	})
+	return garbage, nil
+}
+
+// Repair deletes the garbage in one transaction. A dangling member is kept if the user showed up since.
+// Broken sessions are left in the expiry index, so that their sockets are still told the session expired.
+func (p *Store) Repair(ctx context.Context, garbage Garbage) error {
+	batch := p.NewBatch()
+
+	for _, userId := range garbage.OrphanedUsers {
+		batch.Del(fmt.Sprintf(Const.User, userId))
+	}
+
+	for _, sessionId := range garbage.BrokenSessions {
+		userIds, err := p.sessionUserIds(ctx, sessionId)
+		if err != nil {
+			return err
+		}
+		batch.deleteSession(sessionId, userIds)
+	}
+
+	for _, member := range garbage.DanglingMembers {
+		exists, err := redis.Bool(p.do(ctx, "EXISTS", fmt.Sprintf(Const.User, member.UserId)))
+		if err != nil {
+			return errorx.EnsureStackTrace(err)
+		}
+		if !exists {
+			batch.add("SREM", member.Key, member.UserId)
+		}
+	}
+
+	return p.Exec(ctx, batch)
+}
@@ -0,0 +1,54 @@
+package db
+
+import (
+	"errors"
+	"github.com/gomodule/redigo/redis"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"time"
+)
+
+var (
+	redisDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "ballot_redis_command_duration_seconds",
+		Help:    "Time spent waiting on Redis, by command. Pipelines and transactions count as one.",
+		Buckets: prometheus.ExponentialBuckets(0.0005, 2, 12),
+	}, []string{"command"})
+
+	redisErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ballot_redis_errors_total",
+		Help: "Failed Redis commands, by command and by whether Redis timed out, could not be reached, or refused it.",
+	}, []string{"command", "kind"})
+
+	// PubSubReconnects counts the times a subscriber lost its connection and got a new one
+	PubSubReconnects = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ballot_pubsub_reconnects_total",
+		Help: "Redis pub/sub connections replaced after an error, by subscriber.",
+	}, []string{"subscriber"})
+)
+
+// observe records a finished Redis call
+func observe(cmd string, start time.Time, err error) {
+	if cmd == "" {
+		cmd = "PIPELINE"
+	}
+	redisDuration.WithLabelValues(cmd).Observe(time.Since(start).Seconds())
+
+	if err != nil {
+		redisErrors.WithLabelValues(cmd, errorKind(err)).Inc()
+	}
+}
+
+func errorKind(err error) string {
+	var redisErr redis.Error
+	switch {
+	case IsTimeout(err):
+		return "timeout"
+	case IsUnavailable(err):
+		return "unavailable"
+	case errors.As(err, &redisErr):
+		return "reply"
+	default:
+		return "other"
+	}
+}
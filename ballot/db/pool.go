@@ -0,0 +1,176 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"github.com/gomodule/redigo/redis"
+	"github.com/joomcode/errorx"
+	"github.com/papito/ballot/ballot/config"
+	"net"
+	"net/url"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+var databasePath = regexp.MustCompile(`^/?(\d*)$`)
+
+// ReconnectDelay keeps the subscribers from spinning on reconnects while Redis is down
+const ReconnectDelay = time.Second
+
+// dialer knows where Redis is and how to talk to it. With Sentinel, "where" is decided on every dial.
+type dialer struct {
+	address string
+	options []redis.DialOption
+
+	sentinelAddrs   []string
+	sentinelMaster  string
+	sentinelOptions []redis.DialOption
+}
+
+func NewPool(conf config.Config) (*redis.Pool, error) {
+	d, err := newDialer(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	return &redis.Pool{
+		MaxIdle:     conf.RedisPoolMaxIdle,
+		MaxActive:   conf.RedisPoolMaxActive,
+		Wait:        conf.RedisPoolMaxActive > 0,
+		IdleTimeout: conf.RedisPoolIdleTimeout,
+		DialContext: d.dial,
+		// a connection used in the last minute is fine - pinging on every borrow doubles the round trips
+		TestOnBorrow: func(c redis.Conn, t time.Time) error {
+			if time.Since(t) < time.Minute {
+				return nil
+			}
+			_, err := c.Do("PING")
+			return err
+		},
+	}, nil
+}
+
+func newDialer(conf config.Config) (*dialer, error) {
+	redisUrl, err := url.Parse(conf.RedisUrl)
+	if err != nil {
+		return nil, errorx.EnsureStackTrace(err)
+	}
+	if redisUrl.Scheme != "redis" && redisUrl.Scheme != "rediss" {
+		return nil, fmt.Errorf("invalid Redis URL scheme: %s", redisUrl.Scheme)
+	}
+
+	// same defaults as redis.DialURL
+	host, port, err := net.SplitHostPort(redisUrl.Host)
+	if err != nil {
+		host, port = redisUrl.Host, "6379"
+	}
+	if host == "" {
+		host = "localhost"
+	}
+
+	common := []redis.DialOption{redis.DialConnectTimeout(conf.RedisDialTimeout)}
+	if redisUrl.Scheme == "rediss" {
+		tlsConfig, err := conf.RedisTlsConfig()
+		if err != nil {
+			return nil, err
+		}
+		// the server name is filled in from the address being dialed
+		common = append(common, redis.DialUseTLS(true), redis.DialTLSConfig(tlsConfig))
+	}
+
+	d := &dialer{
+		address:        net.JoinHostPort(host, port),
+		options:        append([]redis.DialOption{}, common...),
+		sentinelAddrs:  conf.RedisSentinelAddrs,
+		sentinelMaster: conf.RedisSentinelMaster,
+	}
+
+	match := databasePath.FindStringSubmatch(redisUrl.Path)
+	if match == nil {
+		return nil, fmt.Errorf("invalid Redis database: %s", redisUrl.Path)
+	}
+	if match[1] != "" {
+		database, _ := strconv.Atoi(match[1])
+		d.options = append(d.options, redis.DialDatabase(database))
+	}
+
+	username, password := conf.RedisUsername, conf.RedisPassword
+	if redisUrl.User != nil && username == "" && password == "" {
+		username = redisUrl.User.Username()
+		password, _ = redisUrl.User.Password()
+	}
+	if username != "" {
+		d.options = append(d.options, redis.DialUsername(username))
+	}
+	if password != "" {
+		d.options = append(d.options, redis.DialPassword(password))
+	}
+
+	d.sentinelOptions = append([]redis.DialOption{}, common...)
+	if conf.RedisSentinelPassword != "" {
+		d.sentinelOptions = append(d.sentinelOptions, redis.DialPassword(conf.RedisSentinelPassword))
+	}
+
+	return d, nil
+}
+
+func (p *dialer) dial(ctx context.Context) (redis.Conn, error) {
+	address := p.address
+
+	if len(p.sentinelAddrs) > 0 {
+		var err error
+		address, err = p.masterAddress(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	c, err := redis.DialContext(ctx, "tcp", address, p.options...)
+	if err != nil {
+		return nil, errorx.EnsureStackTrace(err)
+	}
+
+	if len(p.sentinelAddrs) > 0 {
+		// right after a failover, a Sentinel can still point at the old master
+		role, err := redis.Values(redis.DoContext(c, ctx, "ROLE"))
+		if err != nil || len(role) == 0 {
+			_ = c.Close()
+			return nil, errorx.Decorate(err, "checking role of %s", address)
+		}
+		if roleName, _ := redis.String(role[0], nil); roleName != "master" {
+			_ = c.Close()
+			return nil, fmt.Errorf("%s is a %s, not the master", address, roleName)
+		}
+	}
+
+	return c, nil
+}
+
+// masterAddress asks the Sentinels in turn, until one of them knows the master
+func (p *dialer) masterAddress(ctx context.Context) (string, error) {
+	var lastErr error
+
+	for _, sentinelAddr := range p.sentinelAddrs {
+		c, err := redis.DialContext(ctx, "tcp", sentinelAddr, p.sentinelOptions...)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		master, err := redis.Strings(redis.DoContext(c, ctx, "SENTINEL", "get-master-addr-by-name", p.sentinelMaster))
+		_ = c.Close()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if len(master) != 2 {
+			lastErr = fmt.Errorf("unexpected Sentinel reply from %s: %v", sentinelAddr, master)
+			continue
+		}
+
+		return net.JoinHostPort(master[0], master[1]), nil
+	}
+
+	return "", errorx.Decorate(lastErr, "no Sentinel could tell where master [%s] is", p.sentinelMaster)
+}
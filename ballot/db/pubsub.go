@@ -0,0 +1,84 @@
+package db
+
+import (
+	"errors"
+	"github.com/gomodule/redigo/redis"
+	"sync"
+)
+
+/* A subscriber connection is read by its subscriber goroutine alone, but subscribed and unsubscribed from anywhere -
+as sockets come and go, and on shutdown - and swapped for a new one when it is lost. The lock covers the swap and
+every write. Receiving is left out of it, as it blocks until a message comes, and Redis connections take one reader
+and one writer at a time.
+*/
+
+var errNotConnected = errors.New("not connected")
+
+type PubSub struct {
+	mu   sync.Mutex
+	conn redis.PubSubConn
+}
+
+// Reset swaps the connection for a new one. The old one is closed by the subscriber goroutine, on its way out.
+func (p *PubSub) Reset(conn redis.Conn) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.conn = redis.PubSubConn{Conn: conn}
+	return conn.Err()
+}
+
+// Subscribe does nothing without a connection - the subscriber picks the channels up when it connects
+func (p *PubSub) Subscribe(channels ...interface{}) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.conn.Conn == nil {
+		return nil
+	}
+	return p.conn.Subscribe(channels...)
+}
+
+// Unsubscribe from the channels, or from every channel without any
+func (p *PubSub) Unsubscribe(channels ...interface{}) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.conn.Conn == nil {
+		return nil
+	}
+	return p.conn.Unsubscribe(channels...)
+}
+
+// Receive waits for the next message, subscription or error. Only the subscriber goroutine receives.
+func (p *PubSub) Receive() interface{} {
+	p.mu.Lock()
+	conn := p.conn
+	p.mu.Unlock()
+
+	if conn.Conn == nil {
+		return errNotConnected
+	}
+	return conn.Receive()
+}
+
+// Err is why the connection is no longer usable, nil while it is
+func (p *PubSub) Err() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.conn.Conn == nil {
+		return errNotConnected
+	}
+	return p.conn.Conn.Err()
+}
+
+func (p *PubSub) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.conn.Conn == nil {
+		return nil
+	}
+	return p.conn.Close()
+}
@@ -0,0 +1,255 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gomodule/redigo/redis"
+	"github.com/joomcode/errorx"
+	"github.com/papito/ballot/ballot/model"
+	"strconv"
+	"time"
+)
+
+/* Every finished round is kept in a list, in the order the rounds were finished, for the session export.
+A round is numbered by its place in the list.
+*/
+
+// EndVoting takes the session out of voting. It is true for the one caller that did, and so gets to record
+// the round - a vote is finished both by the last voter and by the facilitator, at times at once.
+func (p *Store) EndVoting(ctx context.Context, sessionId string) (bool, error) {
+	return p.swapState(ctx, sessionId, model.Voting, model.NotVoting)
+}
+
+// swapState sets the session state, and is true if the state was the one expected
+func (p *Store) swapState(ctx context.Context, sessionId string, from int, to int) (bool, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
+	c, err := p.conn(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer p.Close(c)
+
+	key := fmt.Sprintf(Const.SessionState, sessionId)
+	_ = c.Send("GETSET", key, to)
+	_ = c.Send("EXPIRE", key, p.ttl())
+
+	replies, err := redis.Values(p.roundTrip(ctx, c, ""))
+	if err != nil {
+		return false, errorx.EnsureStackTrace(err)
+	}
+	previous, err := redis.String(replies[0], nil)
+	if err == redis.ErrNil {
+		return false, nil
+	}
+	if err != nil {
+		return false, errorx.EnsureStackTrace(err)
+	}
+	return previous == strconv.Itoa(from), nil
+}
+
+// AddRound records a finished round
+func (b *Batch) AddRound(sessionId string, round model.Round) (*Batch, error) {
+	data, err := json.Marshal(round)
+	if err != nil {
+		return b, errorx.EnsureStackTrace(err)
+	}
+	return b.Push(fmt.Sprintf(Const.Rounds, sessionId), data), nil
+}
+
+// LastRound is the last finished round of the session, nil if no round has finished
+func (p *Store) LastRound(ctx context.Context, sessionId string) (*model.Round, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
+	c, err := p.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer p.Close(c)
+
+	key := fmt.Sprintf(Const.Rounds, sessionId)
+	_ = c.Send("LLEN", key)
+	_ = c.Send("LINDEX", key, -1)
+
+	replies, err := redis.Values(p.roundTrip(ctx, c, ""))
+	if err != nil {
+		return nil, errorx.EnsureStackTrace(err)
+	}
+	val, err := redis.Bytes(replies[1], nil)
+	if err == redis.ErrNil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errorx.EnsureStackTrace(err)
+	}
+
+	var round model.Round
+	err = json.Unmarshal(val, &round)
+	if err != nil {
+		return nil, errorx.EnsureStackTrace(err)
+	}
+	round.Number, err = redis.Int(replies[0], nil)
+	if err != nil {
+		return nil, errorx.EnsureStackTrace(err)
+	}
+	return &round, nil
+}
+
+// acceptEstimate rewrites the last round with its accepted estimate, and sets the session estimate - unless a vote
+// is on, or another round finished since the round was read. KEYS are the session state, the rounds and the session
+// estimate, and ARGV the voting state, the number of the round, the round, the estimate and the TTL.
+const acceptEstimate = `
+if redis.call('GET', KEYS[1]) == ARGV[1] or redis.call('LLEN', KEYS[2]) ~= tonumber(ARGV[2]) then
+	return 0
+end
+redis.call('LSET', KEYS[2], -1, ARGV[3])
+redis.call('SET', KEYS[3], ARGV[4], 'EX', ARGV[5])
+return 1`
+
+// AcceptEstimate records the estimate a facilitator accepted for the round, in the round and in the session, and
+// touches the session. It is false, and records nothing, if the round is no longer the last, finished one.
+func (p *Store) AcceptEstimate(ctx context.Context, sessionId string, round model.Round) (bool, error) {
+	data, err := json.Marshal(round)
+	if err != nil {
+		return false, errorx.EnsureStackTrace(err)
+	}
+
+	batch := p.NewBatch()
+	batch.add("EVAL", acceptEstimate, 3,
+		fmt.Sprintf(Const.SessionState, sessionId),
+		fmt.Sprintf(Const.Rounds, sessionId),
+		fmt.Sprintf(Const.Estimate, sessionId),
+		model.Voting, round.Number, data, round.Estimate, batch.ttl)
+
+	replies, err := p.exec(ctx, batch.Touch(sessionId))
+	if err != nil {
+		return false, err
+	}
+	accepted, err := redis.Int(replies[0], nil)
+	if err != nil {
+		return false, errorx.EnsureStackTrace(err)
+	}
+	return accepted == 1, nil
+}
+
+// reopenVote puts a finished vote back into voting: it clears the tally and the accepted estimate, takes the last
+// round off the record, to be recorded again when the vote finishes again, and puts the story of the round, as it is
+// stored, back at the head of the backlog. It does nothing unless a vote has finished and no vote is on. KEYS are the
+// session state, tally, estimate, rounds, story and backlog, and ARGV the voting and not voting states and the TTL.
+const reopenVote = `
+if redis.call('GET', KEYS[1]) ~= ARGV[2] then
+	return 0
+end
+local tally = redis.call('GET', KEYS[2])
+if not tally or tally == '' then
+	return 0
+end
+redis.call('SET', KEYS[1], ARGV[1], 'EX', ARGV[3])
+redis.call('SET', KEYS[2], '', 'EX', ARGV[3])
+redis.call('SET', KEYS[3], '', 'EX', ARGV[3])
+-- a vote finished before rounds were kept has no round to take back
+local round = redis.call('RPOP', KEYS[4])
+if round and cjson.decode(round)['story'] then
+	local story = redis.call('GET', KEYS[5])
+	if story then
+		redis.call('LPUSH', KEYS[6], story)
+		redis.call('EXPIRE', KEYS[6], ARGV[3])
+	end
+end
+return 1`
+
+// ReopenVote puts a finished vote back into voting, and touches the session. It is true for the one caller that
+// did, and so gets to announce it, and false if there was no finished vote to reopen.
+func (p *Store) ReopenVote(ctx context.Context, sessionId string) (bool, error) {
+	batch := p.NewBatch()
+	batch.add("EVAL", reopenVote, 6,
+		fmt.Sprintf(Const.SessionState, sessionId),
+		fmt.Sprintf(Const.Tally, sessionId),
+		fmt.Sprintf(Const.Estimate, sessionId),
+		fmt.Sprintf(Const.Rounds, sessionId),
+		fmt.Sprintf(Const.Story, sessionId),
+		fmt.Sprintf(Const.Backlog, sessionId),
+		model.Voting, model.NotVoting, batch.ttl)
+
+	replies, err := p.exec(ctx, batch.Touch(sessionId))
+	if err != nil {
+		return false, err
+	}
+	reopened, err := redis.Int(replies[0], nil)
+	if err != nil {
+		return false, errorx.EnsureStackTrace(err)
+	}
+	return reopened == 1, nil
+}
+
+// GetRounds are the finished rounds of the session, first to last
+func (p *Store) GetRounds(ctx context.Context, sessionId string) ([]model.Round, error) {
+	vals, err := redis.ByteSlices(p.do(ctx, "LRANGE", fmt.Sprintf(Const.Rounds, sessionId), 0, -1))
+	if err != nil {
+		return nil, errorx.EnsureStackTrace(err)
+	}
+
+	rounds := make([]model.Round, len(vals))
+	for idx, val := range vals {
+		err = json.Unmarshal(val, &rounds[idx])
+		if err != nil {
+			return nil, errorx.EnsureStackTrace(err)
+		}
+		rounds[idx].Number = idx + 1
+	}
+	return rounds, nil
+}
+
+// GetSessionExport is everything needed to export the session, or a not found error if the session does not exist
+func (p *Store) GetSessionExport(ctx context.Context, sessionId string) (model.SessionExport, error) {
+	vals, err := p.GetStrs(ctx,
+		fmt.Sprintf(Const.SessionState, sessionId),
+		fmt.Sprintf(Const.Title, sessionId))
+	if err != nil {
+		return model.SessionExport{}, err
+	}
+	if vals[0] == "" {
+		return model.SessionExport{}, errorx.EnsureStackTrace(redis.ErrNil)
+	}
+
+	userIds, err := p.sessionUserIds(ctx, sessionId)
+	if err != nil {
+		return model.SessionExport{}, err
+	}
+	users, err := p.GetUsersById(ctx, userIds)
+	if err != nil {
+		return model.SessionExport{}, err
+	}
+
+	participants := make([]model.Participant, 0, len(users))
+	for _, user := range users {
+		// a user whose record is gone comes back empty
+		if user.UserId == "" {
+			continue
+		}
+		joined, _ := strconv.ParseInt(user.Joined, 10, 64)
+		participants = append(participants, model.Participant{
+			UserId:     user.UserId,
+			Name:       user.Name,
+			Joined:     time.Unix(0, joined).UTC(),
+			IsObserver: user.IsObserver,
+			IsAdmin:    user.IsAdmin,
+		})
+	}
+
+	rounds, err := p.GetRounds(ctx, sessionId)
+	if err != nil {
+		return model.SessionExport{}, err
+	}
+
+	return model.SessionExport{
+		SessionId:    sessionId,
+		Title:        vals[1],
+		ExportedAt:   time.Now().UTC(),
+		Participants: participants,
+		Rounds:       rounds,
+	}, nil
+}
@@ -0,0 +1,37 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gomodule/redigo/redis"
+	"github.com/joomcode/errorx"
+	"github.com/papito/ballot/ballot/model"
+)
+
+// SetTracker connects the session to an issue tracker, in place of the one it had
+func (b *Batch) SetTracker(sessionId string, settings model.Tracker) (*Batch, error) {
+	data, err := json.Marshal(settings)
+	if err != nil {
+		return b, errorx.EnsureStackTrace(err)
+	}
+	return b.Set(fmt.Sprintf(Const.Tracker, sessionId), data), nil
+}
+
+// GetTracker is the issue tracker of the session, if it has one
+func (p *Store) GetTracker(ctx context.Context, sessionId string) (*model.Tracker, error) {
+	val, err := redis.Bytes(p.do(ctx, "GET", fmt.Sprintf(Const.Tracker, sessionId)))
+	if err == redis.ErrNil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errorx.EnsureStackTrace(err)
+	}
+
+	var settings model.Tracker
+	err = json.Unmarshal(val, &settings)
+	if err != nil {
+		return nil, errorx.EnsureStackTrace(err)
+	}
+	return &settings, nil
+}
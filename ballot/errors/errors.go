@@ -1,18 +1,77 @@
 package errors
 
-type ValidationError struct {
-	Field    string `json:"field"`
-	ErrorStr string `json:"error"`
-}
+import (
+	"errors"
+	"net/http"
+)
+
+/* Every failed API request is answered with an Error. The code is for programs, the message is for people,
+and the field, when there is one, is the request field that was wrong. An uploaded file with several bad rows
+is answered with one Error, with an Error for every row in Errors.
+*/
+
+type Code string
 
-func (e ValidationError) Error() string {
-	return e.ErrorStr
+const (
+	// InvalidRequest is a request body that could not be read
+	InvalidRequest Code = "invalid_request"
+	Validation     Code = "validation"
+	Unauthorized   Code = "unauthorized"
+	Forbidden      Code = "forbidden"
+	NotFound       Code = "not_found"
+	// Conflict is a request that clashes with the state of the session
+	Conflict Code = "conflict"
+	Internal Code = "internal"
+	// Unavailable and Timeout are Redis that cannot be reached, or that is too slow to answer
+	Unavailable Code = "unavailable"
+	Timeout     Code = "timeout"
+	// Upstream is an issue tracker that failed, or could not be reached
+	Upstream Code = "upstream"
+)
+
+var statuses = map[Code]int{
+	InvalidRequest: http.StatusBadRequest,
+	Validation:     http.StatusBadRequest,
+	Unauthorized:   http.StatusUnauthorized,
+	Forbidden:      http.StatusForbidden,
+	NotFound:       http.StatusNotFound,
+	Conflict:       http.StatusConflict,
+	Internal:       http.StatusInternalServerError,
+	Unavailable:    http.StatusServiceUnavailable,
+	Timeout:        http.StatusGatewayTimeout,
+	Upstream:       http.StatusBadGateway,
 }
 
-type CriticalError struct {
+type Error struct {
+	Code    Code   `json:"code"`
 	Message string `json:"message"`
+	Field   string `json:"field,omitempty"`
+	// Row is the row of an uploaded file the error is in, counting from 1
+	Row    int     `json:"row,omitempty"`
+	Errors []Error `json:"errors,omitempty"`
 }
 
-func (e CriticalError) Error() string {
+func (e Error) Error() string {
 	return e.Message
 }
+
+// Status is the HTTP status the error is answered with
+func (e Error) Status() int {
+	status, ok := statuses[e.Code]
+	if !ok {
+		return http.StatusInternalServerError
+	}
+	return status
+}
+
+// As finds the API error in the chain, if there is one
+func As(err error) (Error, bool) {
+	var apiErr Error
+	ok := errors.As(err, &apiErr)
+	return apiErr, ok
+}
+
+func IsNotFound(err error) bool {
+	apiErr, ok := As(err)
+	return ok && apiErr.Code == NotFound
+}
@@ -0,0 +1,228 @@
+package export
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/joomcode/errorx"
+	"github.com/papito/ballot/ballot/model"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/* A session export, as JSON for programs, CSV for spreadsheets and issue tracker imports, and Markdown for
+pasting into a sprint doc. CSV and Markdown have a row for every round, and a column for every voter.
+The CSV has the key and the link of the backlog story of each round, for matching the rounds up with issues.
+The estimate of a round is the one a facilitator accepted, and is empty until they do.
+*/
+
+type Format struct {
+	ContentType string
+	Extension   string
+	Write       func(w io.Writer, export model.SessionExport) error
+}
+
+var Formats = map[string]Format{
+	"json": {ContentType: "application/json", Extension: "json", Write: writeJson},
+	"csv":  {ContentType: "text/csv; charset=utf-8", Extension: "csv", Write: writeCsv},
+	"md":   {ContentType: "text/markdown; charset=utf-8", Extension: "md", Write: writeMarkdown},
+}
+
+const timeFormat = "2006-01-02 15:04 UTC"
+
+func writeJson(w io.Writer, export model.SessionExport) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	err := encoder.Encode(export)
+	if err != nil {
+		return errorx.EnsureStackTrace(err)
+	}
+	return nil
+}
+
+type voter struct {
+	id   string
+	name string
+}
+
+// voters are everyone who can vote, in the order they joined, and then anyone who voted and has since gone
+func voters(export model.SessionExport) []voter {
+	var voters []voter
+	seen := make(map[string]bool)
+	for _, participant := range export.Participants {
+		if !participant.IsObserver {
+			voters = append(voters, voter{id: participant.UserId, name: participant.Name})
+			seen[participant.UserId] = true
+		}
+	}
+	for _, round := range export.Rounds {
+		for _, vote := range round.Votes {
+			if !seen[vote.UserId] {
+				voters = append(voters, voter{id: vote.UserId, name: vote.Name})
+				seen[vote.UserId] = true
+			}
+		}
+	}
+	return voters
+}
+
+func estimates(round model.Round, voters []voter) []string {
+	byUser := make(map[string]string, len(round.Votes))
+	for _, vote := range round.Votes {
+		byUser[vote.UserId] = vote.Estimate
+	}
+	row := make([]string, len(voters))
+	for idx, v := range voters {
+		row[idx] = byUser[v.id]
+	}
+	return row
+}
+
+func writeCsv(w io.Writer, export model.SessionExport) error {
+	voters := voters(export)
+	writer := csv.NewWriter(w)
+
+	header := []string{"Round", "Title", "Key", "Link", "Tally", "Estimate", "Started", "Finished"}
+	for _, v := range voters {
+		header = append(header, csvCell(v.name))
+	}
+	_ = writer.Write(header)
+
+	for _, round := range export.Rounds {
+		story := model.Story{Title: round.Title}
+		if round.Story != nil {
+			story = *round.Story
+		}
+		row := []string{
+			strconv.Itoa(round.Number),
+			csvCell(story.Title),
+			csvCell(story.Key),
+			csvCell(story.Link),
+			csvCell(round.Tally),
+			csvCell(round.Estimate),
+			round.StartedAt.UTC().Format(time.RFC3339),
+			round.FinishedAt.UTC().Format(time.RFC3339),
+		}
+		for _, estimate := range estimates(round, voters) {
+			row = append(row, csvCell(estimate))
+		}
+		_ = writer.Write(row)
+	}
+
+	writer.Flush()
+	err := writer.Error()
+	if err != nil {
+		return errorx.EnsureStackTrace(err)
+	}
+	return nil
+}
+
+// csvCell keeps a spreadsheet from taking text that users typed in for a formula
+func csvCell(s string) string {
+	if s == "" || !strings.ContainsAny(s[:1], "=+-@\t\r") {
+		return s
+	}
+	if _, err := strconv.ParseFloat(s, 64); err == nil {
+		return s
+	}
+	return "'" + s
+}
+
+func writeMarkdown(w io.Writer, export model.SessionExport) error {
+	var md strings.Builder
+
+	title := export.Title
+	if title == "" {
+		title = "Planning session"
+	}
+	_, _ = fmt.Fprintf(&md, "## %s\n\n", mdText(title))
+
+	var voterNames, observerNames []string
+	for _, participant := range export.Participants {
+		if participant.IsObserver {
+			observerNames = append(observerNames, mdText(participant.Name))
+		} else {
+			voterNames = append(voterNames, mdText(participant.Name))
+		}
+	}
+	if len(voterNames) > 0 {
+		_, _ = fmt.Fprintf(&md, "**Voters**: %s  \n", strings.Join(voterNames, ", "))
+	}
+	if len(observerNames) > 0 {
+		_, _ = fmt.Fprintf(&md, "**Observers**: %s  \n", strings.Join(observerNames, ", "))
+	}
+	_, _ = fmt.Fprintf(&md, "**Exported**: %s\n\n", export.ExportedAt.UTC().Format(timeFormat))
+
+	if len(export.Rounds) == 0 {
+		md.WriteString("No rounds were finished.\n")
+		return write(w, md.String())
+	}
+
+	voters := voters(export)
+	header := []string{"#", "Title", "Tally", "Estimate", "Finished"}
+	for _, v := range voters {
+		header = append(header, mdText(v.name))
+	}
+	mdRow(&md, header)
+
+	divider := make([]string, len(header))
+	for idx := range divider {
+		divider[idx] = "---"
+	}
+	mdRow(&md, divider)
+
+	for _, round := range export.Rounds {
+		row := []string{
+			strconv.Itoa(round.Number),
+			mdTitle(round),
+			mdBold(round.Tally),
+			mdBold(round.Estimate),
+			round.FinishedAt.UTC().Format(timeFormat),
+		}
+		for _, estimate := range estimates(round, voters) {
+			row = append(row, mdText(estimate))
+		}
+		mdRow(&md, row)
+	}
+
+	return write(w, md.String())
+}
+
+func write(w io.Writer, s string) error {
+	_, err := io.WriteString(w, s)
+	if err != nil {
+		return errorx.EnsureStackTrace(err)
+	}
+	return nil
+}
+
+func mdRow(md *strings.Builder, cells []string) {
+	md.WriteString("| " + strings.Join(cells, " | ") + " |\n")
+}
+
+// mdTitle is the story of the round, linked when the story has a link, or else the session title
+func mdTitle(round model.Round) string {
+	if round.Story == nil {
+		return mdText(round.Title)
+	}
+	title := strings.TrimSpace(round.Story.Key + " " + round.Story.Title)
+	if round.Story.Link == "" {
+		return mdText(title)
+	}
+	link := strings.NewReplacer("(", "%28", ")", "%29", " ", "%20").Replace(round.Story.Link)
+	return fmt.Sprintf("[%s](%s)", mdText(title), link)
+}
+
+func mdBold(s string) string {
+	if s == "" {
+		return s
+	}
+	return "**" + mdText(s) + "**"
+}
+
+// mdText keeps text users typed in from breaking the table, or the formatting around it
+var mdText = strings.NewReplacer(
+	"\\", "\\\\", "|", "\\|", "*", "\\*", "_", "\\_", "`", "\\`", "<", "&lt;", "\r", "", "\n", " ",
+).Replace
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/papito/ballot/ballot/config"
+	"github.com/papito/ballot/ballot/db"
+	"io"
+)
+
+/* "ballot gc" reports the keys left behind in Redis by sessions and users that are gone, and deletes them with
+-repair. It is safe to run against a live Redis, with Ballot running.
+*/
+
+func gc(ctx context.Context, conf config.Config, args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("ballot gc", flag.ContinueOnError)
+	repair := flags.Bool("repair", false, "delete the garbage found")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	pool, err := db.NewPool(conf)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = pool.Close() }()
+	store := &db.Store{Pool: pool, Timeout: conf.RedisTimeout, SessionTtl: conf.SessionTtl}
+
+	garbage, err := store.FindGarbage(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, userId := range garbage.OrphanedUsers {
+		_, _ = fmt.Fprintf(out, "orphaned user    %s\n", userId)
+	}
+	for _, sessionId := range garbage.BrokenSessions {
+		_, _ = fmt.Fprintf(out, "broken session   %s\n", sessionId)
+	}
+	for _, member := range garbage.DanglingMembers {
+		_, _ = fmt.Fprintf(out, "dangling member  %s %s\n", member.Key, member.UserId)
+	}
+
+	switch {
+	case garbage.Len() == 0:
+		_, _ = fmt.Fprintln(out, "No garbage found")
+	case *repair:
+		err = store.Repair(ctx, garbage)
+		if err != nil {
+			return err
+		}
+		_, _ = fmt.Fprintf(out, "Repaired %d\n", garbage.Len())
+	default:
+		_, _ = fmt.Fprintf(out, "Found %d, run with -repair to delete\n", garbage.Len())
+	}
+	return nil
+}
@@ -1,30 +1,42 @@
 package hub
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/desertbit/glue"
 	"github.com/gomodule/redigo/redis"
 	"github.com/joomcode/errorx"
-	"github.com/papito/ballot/ballot/config"
 	"github.com/papito/ballot/ballot/db"
 	"github.com/papito/ballot/ballot/jsonutil"
+	"github.com/papito/ballot/ballot/logutil"
 	"github.com/papito/ballot/ballot/model"
 	"github.com/papito/ballot/ballot/model/response"
-	"log"
+	"log/slog"
 	"net/http"
-	"os"
+	"net/url"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 /* Modeled after https://github.com/hjr265/tonesa/blob/master/hub/hub.go */
 
 type IHub interface {
 	Connect(store *db.Store)
-	HandleWebSockets(url string)
-	Emit(session string, data string) error
+	WebSocketHandler() http.Handler
+	Emit(ctx context.Context, session string, data string) error
 	EmitLocal(session string, data string)
 	Release()
+
+	// Resubscribe gives a subscriber a new connection, subscribed to the sessions with sockets on this instance
+	Resubscribe(conn *db.PubSub) error
+
+	// Status has the health of each part of the hub, nil for the parts that are fine
+	Status() map[string]error
 }
 
 var Event = struct {
@@ -46,6 +58,9 @@ var Event = struct {
 }
 
 type Hub struct {
+	// Origins sockets may be opened from, besides the server itself. "*" allows any.
+	AllowedOrigins []string
+
 	store       *db.Store
 	socketsMap  map[*glue.Socket]string
 	sessionsMap map[string]map[*glue.Socket]bool
@@ -53,6 +68,12 @@ type Hub struct {
 
 	rwMutex sync.RWMutex
 	glueSrv *glue.Server
+
+	// set on shutdown, when sockets are closed by us and not by users leaving
+	closing atomic.Bool
+
+	// whether the subscriber has a working connection, and is not reconnecting
+	subscribed atomic.Bool
 }
 
 func (p *Hub) Connect(store *db.Store) {
@@ -63,50 +84,167 @@ func (p *Hub) Connect(store *db.Store) {
 
 	go func() {
 		for {
-			p.store.SubConn = redis.PubSubConn{Conn: p.store.Pool.Get()}
+			err := p.resubscribe()
+			if err != nil {
+				slog.Warn("Hub subscriber could not subscribe", logutil.Err(err))
+				_ = p.store.SubConn.Close()
+			}
+			// not ready until every session is subscribed again, or their sockets would hear nothing
+			p.subscribed.Store(err == nil)
 
-			for p.store.SubConn.Conn.Err() == nil {
+			for p.store.SubConn.Err() == nil {
 				switch v := p.store.SubConn.Receive().(type) {
 				case redis.Message:
-					log.Printf(
-						"Subscribe connection received [%s] on channel [%s]", v.Data, v.Channel)
+					slog.Debug("Hub subscriber received", logutil.SessionIdKey, v.Channel, logutil.Event(string(v.Data)))
 					p.EmitLocal(v.Channel, string(v.Data))
+					if isSessionEnded(v.Data) {
+						p.closeSession(v.Channel)
+					}
+				case redis.Subscription:
+					// unsubscribed from everything on shutdown
+					if v.Count == 0 && p.closing.Load() {
+						_ = p.store.SubConn.Close()
+						slog.Info("Hub subscriber connection closed")
+						return
+					}
 				case error:
-					log.Print("PubSub err...or?")
-					fmt.Printf(p.store.SubConn.Conn.Err().Error())
+					slog.Warn("Hub subscriber connection failed", logutil.Err(v))
 				}
 			}
+			p.subscribed.Store(false)
 			_ = p.store.SubConn.Close()
 
-			log.Print("Heroically getting a new connection!")
-			p.store.SubConn = redis.PubSubConn{Conn: p.store.Pool.Get()}
+			if p.closing.Load() {
+				slog.Info("Hub subscriber connection closed")
+				return
+			}
+
+			// the new connection is taken at the top of the loop
+			slog.Warn("Hub subscriber reconnecting")
+			db.PubSubReconnects.WithLabelValues("hub").Inc()
+			time.Sleep(db.ReconnectDelay)
 		}
 	}()
 
 	/* Create the Glue server */
-	env := os.Getenv("ENV")
 	p.glueSrv = glue.NewServer(glue.Options{
 		HTTPSocketType: glue.HTTPSocketTypeNone,
-		CheckOrigin: func(r *http.Request) bool {
-			return env == config.DEV
-		},
+		CheckOrigin:    p.checkOrigin,
 	})
 
 	p.glueSrv.OnNewSocket(p.handleSocket)
 }
 
-func (p *Hub) HandleWebSockets(url string) {
-	http.Handle(url, p.glueSrv)
+// resubscribe takes a new connection for the subscriber, since a lost connection takes its subscriptions with it
+func (p *Hub) resubscribe() error {
+	return p.Resubscribe(&p.store.SubConn)
+}
+
+// Resubscribe swaps the connection of a subscriber for a new one, and subscribes it to the sessions with sockets
+// here. Sockets joining and leaving sessions meanwhile wait on the lock, so no subscription is lost in the swap.
+func (p *Hub) Resubscribe(conn *db.PubSub) error {
+	p.rwMutex.Lock()
+	defer p.rwMutex.Unlock()
+
+	err := conn.Reset(p.store.Pool.Get())
+	if err != nil {
+		return errorx.EnsureStackTrace(err)
+	}
+	if len(p.sessionsMap) == 0 {
+		return nil
+	}
+
+	channels := make([]interface{}, 0, len(p.sessionsMap))
+	for sessionId := range p.sessionsMap {
+		channels = append(channels, sessionId)
+	}
+	err = conn.Subscribe(channels...)
+	if err != nil {
+		return errorx.EnsureStackTrace(err)
+	}
+	return nil
+}
+
+// checkOrigin lets in same-origin sockets, and sockets from the allowed origins
+func (p *Hub) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	originUrl, err := url.Parse(origin)
+	if err == nil && strings.EqualFold(originUrl.Host, r.Host) {
+		return true
+	}
+
+	for _, allowed := range p.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(strings.TrimRight(allowed, "/"), origin) {
+			return true
+		}
+	}
+
+	slog.Warn("Rejected socket", "origin", origin)
+	return false
 }
 
+// WebSocketHandler serves the Glue sockets. Glue expects to be mounted at "/glue/".
+func (p *Hub) WebSocketHandler() http.Handler {
+	return p.glueSrv
+}
+
+// ErrNotSubscribed is the status of a subscriber that lost its Redis connection, and is reconnecting
+var ErrNotSubscribed = errors.New("not connected to Redis")
+
+// Status has the health of the subscriber and of the Glue server, nil for the ones that are fine
+func (p *Hub) Status() map[string]error {
+	status := map[string]error{"hub_subscriber": nil, "glue": nil}
+	if !p.subscribed.Load() {
+		status["hub_subscriber"] = ErrNotSubscribed
+	}
+	switch {
+	case p.glueSrv == nil:
+		status["glue"] = errors.New("not started")
+	case p.closing.Load():
+		status["glue"] = errors.New("shutting down")
+	}
+	return status
+}
+
+// Release tells every socket to reconnect and closes them. Users are not removed from their sessions,
+// since they will be back as soon as they reconnect to another instance, or to this one after a restart.
 func (p *Hub) Release() {
-	log.Print("Releasing Hub resources...")
+	slog.Info("Releasing Hub resources")
+	p.closing.Store(true)
+
+	restarting := response.WsServerRestarting{
+		Event:   response.ServerRestartingEvent,
+		Message: "Server restarting, reconnect",
+	}
+	data, err := json.Marshal(restarting)
+	if err != nil {
+		logutil.Error(context.Background(), errorx.EnsureStackTrace(err))
+	}
+
+	sockets := p.glueSrv.Sockets()
+	slog.Info("Asking sockets to reconnect", "sockets", len(sockets))
+	for _, sock := range sockets {
+		sock.Write(string(data))
+	}
+
+	// blocks new sockets, waits a moment for pending writes, and closes all sockets
 	p.glueSrv.Release()
-	log.Print("Hub done")
+
+	// The subscriber goroutine is blocked reading the connection, and is the one to close it.
+	// Unsubscribing wakes it up, and it closes the connection on its way out.
+	err = p.store.SubConn.Unsubscribe()
+	if err != nil {
+		logutil.Error(context.Background(), errorx.EnsureStackTrace(err))
+	}
+	slog.Info("Hub done")
 }
 
 func (p *Hub) Subscribe(sock *glue.Socket, sessionId string) error {
-	log.Printf("Subscribing socket %s to sessionId %s", sock.ID(), sessionId)
+	slog.Debug("Subscribing socket", "socket_id", sock.ID(), logutil.SessionIdKey, sessionId)
 	p.rwMutex.Lock()
 	defer p.rwMutex.Unlock()
 
@@ -116,6 +254,7 @@ func (p *Hub) Subscribe(sock *glue.Socket, sessionId string) error {
 
 	if !ok {
 		p.sessionsMap[sessionId] = map[*glue.Socket]bool{}
+		activeSessions.Inc()
 		err := p.store.SubConn.Subscribe(sessionId)
 		if err != nil {
 			return errorx.EnsureStackTrace(err)
@@ -131,28 +270,36 @@ func (p *Hub) Subscribe(sock *glue.Socket, sessionId string) error {
 }
 
 func (p *Hub) associateSocketWithUser(sock *glue.Socket, userId string) {
-	log.Printf("Associating user [%s] with socket [%s]", userId, sock.ID())
+	slog.Debug("Associating user with socket", logutil.UserIdKey, userId, "socket_id", sock.ID())
 	p.userMap[sock] = userId
 }
 
 func (p *Hub) disassociateSocketWithUser(sock *glue.Socket) {
 	if userId, ok := p.userMap[sock]; ok {
-		log.Printf("Disassociating user [%s] with socket [%s]", userId, sock.ID())
+		slog.Debug("Disassociating user from socket", logutil.UserIdKey, userId, "socket_id", sock.ID())
 		delete(p.userMap, sock)
 	}
 }
 
 func (p *Hub) unsubscribeAll(sock *glue.Socket) error {
-	log.Printf("Unsubscribing all from socket %s", sock.ID())
+	slog.Debug("Unsubscribing socket from everything", "socket_id", sock.ID())
 	p.rwMutex.Lock()
 	defer p.rwMutex.Unlock()
 
+	if p.closing.Load() {
+		if sessionId, ok := p.socketsMap[sock]; ok {
+			p.leaveSession(sock, sessionId)
+		}
+		delete(p.socketsMap, sock)
+		p.disassociateSocketWithUser(sock)
+		return nil
+	}
+
 	if sessionId, ok := p.socketsMap[sock]; ok {
-		delete(p.sessionsMap[sessionId], sock)
+		ctx := logutil.WithSessionId(context.Background(), sessionId)
 
-		if len(p.sessionsMap[sessionId]) == 0 {
-			delete(p.sessionsMap, sessionId)
-			log.Printf("Unsubscribing from sessionId [%s] - no sockets connecting", sessionId)
+		if p.leaveSession(sock, sessionId) {
+			slog.DebugContext(ctx, "Unsubscribing from session, no sockets left")
 			err := p.store.SubConn.Unsubscribe(sessionId)
 			if err != nil {
 				return errorx.EnsureStackTrace(err)
@@ -160,8 +307,9 @@ func (p *Hub) unsubscribeAll(sock *glue.Socket) error {
 		}
 
 		userId, _ := p.userMap[sock]
+		ctx = logutil.WithUserId(ctx, userId)
 
-		user, err := p.store.GetUser(userId)
+		user, err := p.store.GetUser(ctx, userId)
 		if err != nil {
 			return errorx.EnsureStackTrace(err)
 		}
@@ -187,7 +335,7 @@ func (p *Hub) unsubscribeAll(sock *glue.Socket) error {
 		if err != nil {
 			return errorx.EnsureStackTrace(err)
 		}
-		err = p.Emit(sessionId, string(data))
+		err = p.Emit(ctx, sessionId, string(data))
 		if err != nil {
 			return errorx.EnsureStackTrace(err)
 		}
@@ -197,11 +345,21 @@ func (p *Hub) unsubscribeAll(sock *glue.Socket) error {
 	return nil
 }
 
-func (p *Hub) Emit(session string, data string) error {
-	log.Printf("EMIT. Session %s - %s", session, data)
-	c := p.store.Pool.Get()
-	defer p.store.Close(c)
-	_, err := c.Do("PUBLISH", session, data)
+// leaveSession is true when the socket was the last one in the session
+func (p *Hub) leaveSession(sock *glue.Socket, sessionId string) bool {
+	delete(p.sessionsMap[sessionId], sock)
+	if len(p.sessionsMap[sessionId]) > 0 {
+		return false
+	}
+
+	delete(p.sessionsMap, sessionId)
+	activeSessions.Dec()
+	return true
+}
+
+func (p *Hub) Emit(ctx context.Context, session string, data string) error {
+	slog.DebugContext(ctx, "Emit", logutil.SessionIdKey, session, logutil.Event(data))
+	err := p.store.Publish(ctx, session, data)
 
 	if err != nil {
 		return errorx.EnsureStackTrace(err)
@@ -211,7 +369,7 @@ func (p *Hub) Emit(session string, data string) error {
 }
 
 func (p *Hub) EmitLocal(session string, data string) {
-	log.Printf("EMIT LOCAL. Session %s - %s", session, data)
+	slog.Debug("Emit locally", logutil.SessionIdKey, session, logutil.Event(data))
 	p.rwMutex.RLock()
 	defer p.rwMutex.RUnlock()
 
@@ -221,8 +379,58 @@ func (p *Hub) EmitLocal(session string, data string) {
 	}
 }
 
+// closeSessionDelay gives the SESSION_CLOSED event a moment to go out, before the sockets are closed
+const closeSessionDelay = 200 * time.Millisecond
+
+// isSessionEnded is true for the events that end a session: closed by a facilitator, or expired
+func isSessionEnded(data []byte) bool {
+	for _, event := range []string{response.SessionClosedEvent, response.SessionExpiredEvent} {
+		if bytes.Contains(data, []byte(event)) {
+			var ended response.WsSessionClosed
+			err := json.Unmarshal(data, &ended)
+			return err == nil && ended.Event == event
+		}
+	}
+	return false
+}
+
+// closeSession disconnects the sockets of a closed or expired session. They are taken out of the session first,
+// so that closing them does not announce users leaving a session that is gone.
+func (p *Hub) closeSession(sessionId string) {
+	ctx := logutil.WithSessionId(context.Background(), sessionId)
+	p.rwMutex.Lock()
+
+	var sockets []*glue.Socket
+	emptied := false
+	for sock := range p.sessionsMap[sessionId] {
+		sockets = append(sockets, sock)
+		emptied = p.leaveSession(sock, sessionId)
+		delete(p.socketsMap, sock)
+		p.disassociateSocketWithUser(sock)
+	}
+
+	if emptied {
+		err := p.store.SubConn.Unsubscribe(sessionId)
+		if err != nil {
+			logutil.Error(ctx, errorx.EnsureStackTrace(err))
+		}
+		err = p.store.ServiceSubCon.Unsubscribe(sessionId)
+		if err != nil {
+			logutil.Error(ctx, errorx.EnsureStackTrace(err))
+		}
+	}
+	p.rwMutex.Unlock()
+
+	slog.InfoContext(ctx, "Disconnecting the sockets of a session that ended", "sockets", len(sockets))
+	time.AfterFunc(closeSessionDelay, func() {
+		for _, sock := range sockets {
+			sock.Close()
+		}
+	})
+}
+
 func (p *Hub) emitSocket(sock *glue.Socket, data string) {
-	log.Printf("EMIT SOCKET. Socket %s - %s", sock.ID(), data)
+	slog.Debug("Emit to socket", "socket_id", sock.ID(), logutil.Event(data))
 	p.rwMutex.RLock()
 	defer p.rwMutex.RUnlock()
 
@@ -230,50 +438,53 @@ func (p *Hub) emitSocket(sock *glue.Socket, data string) {
 }
 
 func (p *Hub) handleSocket(sock *glue.Socket) {
-	log.Printf("Handling socket %s", sock.ID())
+	slog.Debug("Socket connected", "socket_id", sock.ID())
+	connectedSockets.Inc()
 
 	sock.OnClose(func() {
-		log.Printf("Socket %s closed", sock.ID())
+		slog.Debug("Socket closed", "socket_id", sock.ID())
+		connectedSockets.Dec()
 
 		err := p.unsubscribeAll(sock)
 		if err != nil {
-			log.Printf("%+v", errorx.EnsureStackTrace(err))
+			logutil.Error(context.Background(), errorx.EnsureStackTrace(err))
 			return
 		}
 	})
 
 	sock.OnRead(func(data string) {
-		log.Printf("Reading from socket %s: %s", sock.ID(), data)
+		slog.Debug("Reading from socket", "socket_id", sock.ID(), "data", data)
 
-		c := p.store.Pool.Get()
-		defer p.store.Close(c)
+		// socket events are not tied to a request - the store timeout still applies
+		ctx := context.Background()
 
 		jsonData, err := jsonutil.GetJsonFromString(data)
 		if err != nil {
-			log.Printf("%+v", err)
+			logutil.Error(ctx, err)
 			return
 		}
 
 		var sessionId = jsonData["session_id"].(string)
 		var action = jsonData["action"].(string)
+		ctx = logutil.WithSessionId(ctx, sessionId)
 
 		switch action {
 		/*
 		   Emit the WATCHING event, as well as a list of current users in this session
 		*/
 		case Event.Watch:
-			log.Printf("WS. Watching session %s", sessionId)
+			slog.DebugContext(ctx, "Watching session")
 			err := p.Subscribe(sock, sessionId)
 			if err != nil {
-				log.Printf("%+v", err)
+				logutil.Error(ctx, err)
 				return
 			}
 
 			// get session state - voting, not voting
 			key := fmt.Sprintf(db.Const.SessionState, sessionId)
-			isVoting, err := redis.Int(c.Do("GET", key))
+			isVoting, err := p.store.GetInt(ctx, key)
 			if err != nil {
-				log.Printf("%+v", err)
+				logutil.Error(ctx, err)
 				return
 			}
 
@@ -283,31 +494,37 @@ func (p *Hub) handleSocket(sock *glue.Socket) {
 			}
 
 			if userId, ok := jsonData["user_id"].(string); ok {
+				ctx = logutil.WithUserId(ctx, userId)
 				p.associateSocketWithUser(sock, userId)
 
-				user, err := p.store.GetUser(userId)
+				user, err := p.store.GetUser(ctx, userId)
 				if err != nil {
-					log.Printf("%+v", err)
+					logutil.Error(ctx, err)
 					return
 				}
 
 				if user.IsObserver {
 					sessionObserverKey := fmt.Sprintf(db.Const.SessionObservers, sessionId)
-					log.Printf("Adding observer [%s] to session [%s]", userId, sessionId)
-					err = p.store.AddToSet(sessionObserverKey, userId)
+					slog.InfoContext(ctx, "Adding observer to session")
+					err = p.store.AddToSet(ctx, sessionObserverKey, userId)
 					if err != nil {
 						return
 					}
 
 				} else {
 					sessionUserKey := fmt.Sprintf(db.Const.SessionUsers, sessionId)
-					log.Printf("Adding voter [%s] to session [%s]", userId, sessionId)
-					err = p.store.AddToSet(sessionUserKey, userId)
+					slog.InfoContext(ctx, "Adding voter to session")
+					err = p.store.AddToSet(ctx, sessionUserKey, userId)
 					if err != nil {
 						return
 					}
 				}
 
+				err = p.store.TouchSession(ctx, sessionId)
+				if err != nil {
+					logutil.Error(ctx, err)
+				}
+
 				wsUser := response.WsNewUser{}
 				if user.IsObserver {
 					wsUser.Event = response.ObserverAddedEvent
@@ -330,76 +547,58 @@ func (p *Hub) handleSocket(sock *glue.Socket) {
 
 				wsResp, err := json.Marshal(wsUser)
 				if err != nil {
-					log.Printf("%+v", err)
+					logutil.Error(ctx, err)
 					return
 				}
 
-				err = p.Emit(sessionId, string(wsResp))
+				err = p.Emit(ctx, sessionId, string(wsResp))
 				if err != nil {
-					log.Printf("%+v", err)
+					logutil.Error(ctx, err)
 					return
 				}
 			}
 
-			users, err := p.store.GetSessionVoters(sessionId)
+			snapshot, err := p.store.GetSession(ctx, sessionId)
 			if err != nil {
-				log.Printf("%+v", err)
+				logutil.Error(ctx, err)
 				return
 			}
 
-			// null out estimates if still voting
-			for idx := range users {
-				if sessionState == model.Voting {
-					users[idx].Estimate = model.NoEstimate
-				}
-			}
-
-			observers, err := p.store.GetSessionObservers(sessionId)
-			if err != nil {
-				log.Printf("%+v", err)
-				return
-			}
-
-			key = fmt.Sprintf(db.Const.Tally, sessionId)
-			tally, err := p.store.GetStr(key)
-			if err != nil {
-				log.Printf("%+v", err)
-			}
-
 			session := response.WsSession{
 				Event:        Event.Watching,
-				SessionState: sessionState,
-				Users:        users,
-				Observers:    observers,
-				Tally:        tally,
+				SessionState: snapshot.SessionState,
+				Users:        snapshot.Users,
+				Observers:    snapshot.Observers,
+				Tally:        snapshot.Tally,
+				Estimate:     snapshot.Estimate,
 			}
 
 			data, err := json.Marshal(session)
 			if err != nil {
-				log.Printf("%+v", err)
+				logutil.Error(ctx, err)
 				return
 			}
 
 			p.emitSocket(sock, string(data))
 
 		case Event.Start:
-			err := p.Emit(sessionId, "{}")
+			err := p.Emit(ctx, sessionId, "{}")
 			if err != nil {
-				log.Printf("%+v", err)
+				logutil.Error(ctx, err)
 				return
 			}
 
 		case Event.Restart:
-			err := p.Emit(sessionId, "{}")
+			err := p.Emit(ctx, sessionId, "{}")
 			if err != nil {
-				log.Printf("%+v", err)
+				logutil.Error(ctx, err)
 				return
 			}
 
 		case Event.Vote:
-			err := p.Emit(sessionId, "{}")
+			err := p.Emit(ctx, sessionId, "{}")
 			if err != nil {
-				log.Printf("%+v", err)
+				logutil.Error(ctx, err)
 				return
 			}
 		}
@@ -411,9 +610,11 @@ func (p *Hub) handleSocket(sock *glue.Socket) {
 type VoidHub struct {
 	Emitted      []string
 	LocalEmitted []string
+
+	store *db.Store
 }
 
-func (p *VoidHub) Emit(_ string, data string) error {
+func (p *VoidHub) Emit(_ context.Context, _ string, data string) error {
 	p.Emitted = append(p.Emitted, data)
 	return nil
 }
@@ -423,10 +624,19 @@ func (p *VoidHub) EmitLocal(_ string, data string) {
 }
 
 // Connect This VOID version resets the state
-func (p *VoidHub) Connect(_ *db.Store) {
+func (p *VoidHub) Connect(store *db.Store) {
+	p.store = store
 	p.Emitted = p.Emitted[:0]
 	p.LocalEmitted = p.LocalEmitted[:0]
 }
 
-func (p *VoidHub) HandleWebSockets(_ string) { return }
-func (p *VoidHub) Release()                  { return }
+func (p *VoidHub) WebSocketHandler() http.Handler { return http.NotFoundHandler() }
+func (p *VoidHub) Release()                       { return }
+
+// Status is empty, as there is nothing here to fail
+func (p *VoidHub) Status() map[string]error { return map[string]error{} }
+
+// Resubscribe only swaps the connection, as there are no sockets
+func (p *VoidHub) Resubscribe(conn *db.PubSub) error {
+	return conn.Reset(p.store.Pool.Get())
+}
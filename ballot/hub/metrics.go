@@ -0,0 +1,18 @@
+package hub
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	connectedSockets = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "ballot_hub_sockets",
+		Help: "Sockets connected to this instance.",
+	})
+
+	activeSessions = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "ballot_active_sessions",
+		Help: "Sessions with at least one socket connected to this instance.",
+	})
+)
@@ -1,9 +1,135 @@
 package logutil
 
-import "log"
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+)
+
+/* Log lines are tagged with the request, session and user they are about, so that everything that happened
+to one session can be pulled out of the logs. The IDs travel in the context: tag it once with WithSessionId
+and friends, and pass it to the slog *Context functions.
+*/
+
+const (
+	RequestIdKey = "request_id"
+	SessionIdKey = "session_id"
+	UserIdKey    = "user_id"
+
+	// user names are redacted, unless asked otherwise
+	UserNameKey = "user_name"
+)
+
+const redacted = "REDACTED"
+
+type attrsKey struct{}
 
 func Logger(_ int, err error) {
 	if err != nil {
-		log.Printf("Write failed: %v", err)
+		slog.Error("Write failed", Err(err))
+	}
+}
+
+// NewLogger logs in the text or json format. User names come out as REDACTED unless showUserNames is set.
+func NewLogger(w io.Writer, level slog.Level, format string, showUserNames bool) *slog.Logger {
+	opts := &slog.HandlerOptions{
+		Level: level,
+		ReplaceAttr: func(_ []string, a slog.Attr) slog.Attr {
+			if a.Key == UserNameKey && !showUserNames {
+				return slog.String(UserNameKey, redacted)
+			}
+			return a
+		},
+	}
+
+	var handler slog.Handler
+	if format == "json" {
+		handler = slog.NewJSONHandler(w, opts)
+	} else {
+		handler = slog.NewTextHandler(w, opts)
+	}
+	return slog.New(contextHandler{handler})
+}
+
+// With tags the context with log attributes. An attribute that is already there is replaced.
+func With(ctx context.Context, attrs ...slog.Attr) context.Context {
+	existing, _ := ctx.Value(attrsKey{}).([]slog.Attr)
+	merged := make([]slog.Attr, 0, len(existing)+len(attrs))
+
+	for _, attr := range existing {
+		replaced := false
+		for _, newAttr := range attrs {
+			if newAttr.Key == attr.Key {
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			merged = append(merged, attr)
+		}
+	}
+
+	return context.WithValue(ctx, attrsKey{}, append(merged, attrs...))
+}
+
+func WithRequestId(ctx context.Context, requestId string) context.Context {
+	return With(ctx, slog.String(RequestIdKey, requestId))
+}
+
+func WithSessionId(ctx context.Context, sessionId string) context.Context {
+	return With(ctx, slog.String(SessionIdKey, sessionId))
+}
+
+func WithUserId(ctx context.Context, userId string) context.Context {
+	return With(ctx, slog.String(UserIdKey, userId))
+}
+
+// UserName is redacted, unless the logger was created to show user names
+func UserName(name string) slog.Attr {
+	return slog.String(UserNameKey, name)
+}
+
+// Event is the name of the event in a message. The body is left out - it can carry user names.
+func Event(data string) slog.Attr {
+	var message struct {
+		Event string `json:"event"`
+	}
+	_ = json.Unmarshal([]byte(data), &message)
+	return slog.String("event", message.Event)
+}
+
+// Err is the error with its stack trace, when it has one
+func Err(err error) slog.Attr {
+	return slog.String("error", fmt.Sprintf("%+v", err))
+}
+
+// Error logs an error, with its stack trace when it has one, along with whatever the context is tagged with
+func Error(ctx context.Context, err error) {
+	var attrs []any
+	if trace := fmt.Sprintf("%+v", err); trace != err.Error() {
+		attrs = append(attrs, slog.String("stack", trace))
+	}
+	slog.ErrorContext(ctx, err.Error(), attrs...)
+}
+
+// contextHandler adds the attributes the context is tagged with to every record
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if attrs, ok := ctx.Value(attrsKey{}).([]slog.Attr); ok {
+		r.AddAttrs(attrs...)
 	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
 }
@@ -1,5 +1,7 @@
 package model
 
+import "time"
+
 type Session struct {
 	SessionId string `json:"id"`
 }
@@ -14,6 +16,75 @@ type User struct {
 	IsAdmin    bool   `json:"is_admin"`
 }
 
+// SessionSnapshot is the state of a session, as a newcomer sees it. Estimates are only there once revealed.
+type SessionSnapshot struct {
+	SessionId    string `json:"id"`
+	SessionState int    `json:"status"`
+	Title        string `json:"title"`
+	Users        []User `json:"users"`
+	Observers    []User `json:"observers"`
+	Tally        string `json:"tally"`
+	// the estimate a facilitator accepted for the last round, empty until they do
+	Estimate string `json:"estimate"`
+	// the backlog story of the current or last round, if it had one
+	Story *Story `json:"story,omitempty"`
+}
+
+// Story is an item of the backlog, estimated in a round of its own
+type Story struct {
+	Key   string `json:"key"`
+	Title string `json:"title"`
+	Link  string `json:"link"`
+}
+
+// Tracker is the issue tracker a session is connected to. The token is never sent back.
+type Tracker struct {
+	Kind  string `json:"kind"`
+	Repo  string `json:"repo"`
+	Token string `json:"token,omitempty"`
+	// Label is put in front of an estimate, to make the label it is written back as
+	Label string `json:"label"`
+}
+
+// Round is a finished vote, with how everyone in it voted
+type Round struct {
+	Number     int       `json:"number"`
+	Title      string    `json:"title"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	Tally      string    `json:"tally"`
+	// the estimate a facilitator accepted, which may differ from the tally
+	Estimate string `json:"estimate,omitempty"`
+	Votes    []Vote `json:"votes"`
+	// the backlog story estimated, if there was one
+	Story *Story `json:"story,omitempty"`
+}
+
+// Vote is one voter's estimate in a round, empty if they did not vote
+type Vote struct {
+	UserId   string `json:"user_id"`
+	Name     string `json:"name"`
+	Estimate string `json:"estimate"`
+}
+
+// Participant is anyone who joined the session, whether they are still there or not
+type Participant struct {
+	UserId     string    `json:"id"`
+	Name       string    `json:"name"`
+	Joined     time.Time `json:"joined"`
+	IsObserver bool      `json:"is_observer"`
+	IsAdmin    bool      `json:"is_admin"`
+}
+
+// SessionExport is the record of a session: who was there, and every finished round
+type SessionExport struct {
+	SessionId    string        `json:"id"`
+	Title        string        `json:"title"`
+	ExportedAt   time.Time     `json:"exported_at"`
+	Participants []Participant `json:"participants"`
+	Rounds       []Round       `json:"rounds"`
+}
+
 type PendingVote struct {
 	SessionId string `json:"session_id"`
 	UserId    string `json:"user_id"`
@@ -15,8 +15,36 @@ type FinishVoteRequest struct {
 	SessionId string `json:"session_id"`
 }
 
+// ReopenVoteRequest takes back the last finished vote. Only a facilitator of the session can.
+type ReopenVoteRequest struct {
+	SessionId string `json:"session_id"`
+	UserId    string `json:"user_id"`
+}
+
+// AcceptEstimateRequest settles the estimate of the last round. Only a facilitator of the session can.
+type AcceptEstimateRequest struct {
+	SessionId string `json:"session_id"`
+	UserId    string `json:"user_id"`
+	Estimate  string `json:"estimate"`
+}
+
 type CastVoteRequest struct {
 	UserId    string `json:"user_id"`
 	SessionId string `json:"session_id"`
 	Estimate  string `json:"estimate"`
 }
+
+// SetTrackerRequest connects a session to an issue tracker. Only a facilitator of the session can.
+type SetTrackerRequest struct {
+	UserId string `json:"user_id"`
+	Kind   string `json:"kind"`
+	Repo   string `json:"repo"`
+	Token  string `json:"token"`
+	Label  string `json:"label"`
+}
+
+// ImportIssuesRequest queues the issues the query matches. Only a facilitator of the session can.
+type ImportIssuesRequest struct {
+	UserId string `json:"user_id"`
+	Query  string `json:"query"`
+}
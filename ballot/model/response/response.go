@@ -1,13 +1,42 @@
 package response
 
-import "github.com/papito/ballot/ballot/model"
+import (
+	"github.com/papito/ballot/ballot/model"
+	"time"
+)
 
 type HealthResponse struct {
 	Status string `json:"status"`
 }
 
+const (
+	HealthOk       = "OK"
+	HealthDown     = "DOWN"
+	HealthDegraded = "DEGRADED"
+)
+
+type ComponentHealth struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// ReadinessResponse is DEGRADED when any of the components is DOWN
+type ReadinessResponse struct {
+	Status     string                     `json:"status"`
+	Components map[string]ComponentHealth `json:"components"`
+}
+
+// BacklogResponse is the stories still to be estimated, in order
+type BacklogResponse struct {
+	Stories []model.Story `json:"stories"`
+}
+
+// WsVoteStarted is a new vote, or a finished one reopened. A reopened vote has the voters, with who voted
+// already, and their estimates hidden again.
 type WsVoteStarted struct {
-	Event string `json:"event"`
+	Event    string       `json:"event"`
+	Reopened bool         `json:"reopened,omitempty"`
+	Users    []model.User `json:"users,omitempty"`
 }
 
 type WsVoteFinished struct {
@@ -32,6 +61,7 @@ type WsSession struct {
 	Users        []model.User `json:"users"`
 	Observers    []model.User `json:"observers"`
 	Tally        string       `json:"tally"`
+	Estimate     string       `json:"estimate"`
 }
 
 type WsUserLeftEvent struct {
@@ -46,10 +76,43 @@ type WsObserverLeftEvent struct {
 	UserId    string `json:"user_id"`
 }
 
+type WsSessionClosed struct {
+	Event     string `json:"event"`
+	SessionId string `json:"session_id"`
+}
+
+type WsSessionExpiring struct {
+	Event     string    `json:"event"`
+	SessionId string    `json:"session_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type WsEstimateAccepted struct {
+	Event    string `json:"event"`
+	Round    int    `json:"round"`
+	Tally    string `json:"tally"`
+	Estimate string `json:"estimate"`
+}
+
+type WsServerRestarting struct {
+	Event   string `json:"event"`
+	Message string `json:"message"`
+}
+
 const (
 	UserAddedEvent     = "USER_ADDED"
 	ObserverAddedEvent = "OBSERVER_ADDED"
 	UserVotedEVent     = "USER_VOTED"
 	VoteStartedEVent   = "VOTING"
 	VoteFinishedEvent  = "VOTE_FINISHED"
+	// Sent when a facilitator closes the session. Its sockets are then disconnected, and its data is gone.
+	SessionClosedEvent = "SESSION_CLOSED"
+	// Sent shortly before an idle session expires. Any activity in the session keeps it around.
+	SessionExpiringEvent = "SESSION_EXPIRING"
+	// Sent when an idle session has expired. Its sockets are then disconnected, same as when it is closed.
+	SessionExpiredEvent = "SESSION_EXPIRED"
+	// Sent when a facilitator accepts an estimate for the last round, in place of its tally
+	EstimateAcceptedEvent = "ESTIMATE_ACCEPTED"
+	// Sent to every socket on shutdown. Clients should reconnect, and will be put back in their sessions.
+	ServerRestartingEvent = "SERVER_RESTARTING"
 )
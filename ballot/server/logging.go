@@ -0,0 +1,26 @@
+package server
+
+import (
+	"github.com/google/uuid"
+	"github.com/papito/ballot/ballot/logutil"
+	"log/slog"
+	"net/http"
+)
+
+const requestIdHeader = "X-Request-Id"
+
+// withRequestId tags the request with the request ID the caller sent, or with a new one, so that
+// every log line for the request carries it. The ID is sent back in the response.
+func withRequestId(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestId := r.Header.Get(requestIdHeader)
+		if requestId == "" || len(requestId) > 128 {
+			requestId = uuid.NewString()
+		}
+		w.Header().Set(requestIdHeader, requestId)
+
+		ctx := logutil.WithRequestId(r.Context(), requestId)
+		slog.DebugContext(ctx, "HTTP request", "method", r.Method, "path", r.URL.Path)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,31 @@
+package server
+
+import (
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+)
+
+var httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "ballot_http_request_duration_seconds",
+	Help:    "Time to answer HTTP requests, by route, method and status code.",
+	Buckets: prometheus.DefBuckets,
+}, []string{"route", "method", "code"})
+
+// instrument times requests by the route they matched, so that session and user IDs do not end up in labels
+func instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route, _ := mux.CurrentRoute(r).GetPathTemplate()
+
+		// a socket is open for as long as the user is around, which says nothing about latency
+		if route == socketRoute {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		observer := httpDuration.MustCurryWith(prometheus.Labels{"route": route})
+		promhttp.InstrumentHandlerDuration(observer, next).ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,50 @@
+package server
+
+import (
+	_ "embed"
+	"github.com/gorilla/mux"
+	"github.com/papito/ballot/ballot/logutil"
+	"net/http"
+	"sort"
+)
+
+/* The OpenAPI document is written by hand. A test checks it against the router, and the schemas against the
+request and response models, so a route or a field that is added without documenting it fails the build.
+*/
+
+//go:embed openapi.json
+var openApi []byte
+
+func openApiHandler(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	logutil.Logger(w.Write(openApi))
+}
+
+// Route is a path template, such as "/api/user/{id}", with the methods it answers to
+type Route struct {
+	Path    string
+	Methods []string
+}
+
+// Routes are the routes that answer to set methods, which leaves out the UI and the socket
+func (p server) Routes() []Route {
+	var routes []Route
+	_ = p.router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			return nil
+		}
+		routes = append(routes, Route{Path: path, Methods: methods})
+		return nil
+	})
+
+	sort.Slice(routes, func(i, j int) bool {
+		return routes[i].Path < routes[j].Path
+	})
+	return routes
+}
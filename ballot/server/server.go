@@ -1,158 +1,472 @@
 package server
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"github.com/gorilla/mux"
-	"github.com/joomcode/errorx"
+	"github.com/papito/ballot/ballot/backlog"
 	"github.com/papito/ballot/ballot/config"
+	"github.com/papito/ballot/ballot/db"
 	"github.com/papito/ballot/ballot/errors"
+	"github.com/papito/ballot/ballot/export"
 	"github.com/papito/ballot/ballot/jsonutil"
 	"github.com/papito/ballot/ballot/logutil"
 	"github.com/papito/ballot/ballot/model"
 	"github.com/papito/ballot/ballot/model/request"
 	"github.com/papito/ballot/ballot/model/response"
 	"github.com/papito/ballot/ballot/service"
-	"log"
+	"github.com/papito/ballot/ballot/slash"
+	"github.com/papito/ballot/ballot/ui"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"io"
+	"io/fs"
+	"log/slog"
 	"net/http"
+	"net/url"
 	"os"
-	"path/filepath"
+	"strings"
+	"time"
 )
 
 type Server interface {
+	http.Handler
 	Release()
 	HealthHttpHandler(w http.ResponseWriter, r *http.Request)
+	ReadinessHttpHandler(w http.ResponseWriter, r *http.Request)
 	CreateSessionHttpHandler(w http.ResponseWriter, r *http.Request)
+	GetSessionHttpHandler(w http.ResponseWriter, r *http.Request)
+	CloseSessionHttpHandler(w http.ResponseWriter, r *http.Request)
 	CreateUserHttpHandler(w http.ResponseWriter, r *http.Request)
 	GetUserHttpHandler(w http.ResponseWriter, r *http.Request)
 	StartVoteHttpHandler(w http.ResponseWriter, r *http.Request)
 	FinishVoteHttpHandler(w http.ResponseWriter, r *http.Request)
 	CastVoteHttpHandler(w http.ResponseWriter, r *http.Request)
+	SlashCommandHttpHandler(w http.ResponseWriter, r *http.Request)
 	Service() *service.Service
+	Routes() []Route
 }
 
+// Glue expects the socket at this path
+const socketRoute = "/glue/ws"
+
 type server struct {
 	service *service.Service
+	handler http.Handler
+	router  *mux.Router
 }
 
-// Lifted straight from Gorilla Mux documentation
-// https://github.com/gorilla/mux?tab=readme-ov-file#serving-single-page-applications
-type spaHandler struct {
-	staticPath string
-	indexPath  string
+type options struct {
+	basePath   string
+	middleware []func(http.Handler) http.Handler
 }
 
-// ServeHTTP inspects the URL path to locate a file within the static dir
-// on the SPA handler. If a file is found, it will be served. If not, the
-// file located at the index path on the SPA handler will be served. This
-// is suitable behavior for serving an SPA (single page application).
-func (h spaHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	// Join internally call path.Clean to prevent directory traversal
-	path := filepath.Join(h.staticPath, r.URL.Path)
+type Option func(*options)
 
-	// check whether a file exists or is a directory at the given path
-	fi, err := os.Stat(path)
-	if os.IsNotExist(err) || fi.IsDir() {
-		// file does not exist or path is a directory, serve index.html
-		http.ServeFile(w, r, filepath.Join(h.staticPath, h.indexPath))
-		return
+// WithBasePath mounts the server under a path prefix, such as "/ballot"
+func WithBasePath(basePath string) Option {
+	return func(o *options) {
+		o.basePath = strings.TrimRight(basePath, "/")
 	}
+}
 
-	if err != nil {
-		// if we got an error (that wasn't that the file doesn't exist) stating the
-		// file, return a 500 internal server error and stop
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+// WithMiddleware wraps the server handler. The first middleware given is the outermost one.
+func WithMiddleware(middleware ...func(http.Handler) http.Handler) Option {
+	return func(o *options) {
+		o.middleware = append(o.middleware, middleware...)
 	}
-
-	// otherwise, use http.FileServer to serve the static file
-	http.FileServer(http.Dir(h.staticPath)).ServeHTTP(w, r)
 }
 
-func NewServer(config config.Config) Server {
-	log.Println("Creating server")
+func NewServer(config config.Config, opts ...Option) Server {
+	slog.Debug("Creating server")
 	ballotService := service.NewService(config)
 
+	o := options{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
 	server := server{
 		service: &ballotService,
 	}
 
 	// Handlers
 	r := mux.NewRouter()
+	r.Use(withRequestId, instrument)
+	server.router = r
 	r.HandleFunc("/health", server.HealthHttpHandler).Methods("GET")
+	r.HandleFunc("/health/live", server.HealthHttpHandler).Methods("GET")
+	r.HandleFunc("/health/ready", server.ReadinessHttpHandler).Methods("GET")
+	r.HandleFunc("/api/openapi.json", openApiHandler).Methods("GET")
 	r.HandleFunc("/api/session", server.CreateSessionHttpHandler).Methods("POST")
+	r.HandleFunc("/api/session/{id}", server.GetSessionHttpHandler).Methods("GET", "HEAD")
+	r.HandleFunc("/api/session/{id}", server.CloseSessionHttpHandler).Methods("DELETE")
+	r.HandleFunc("/api/session/{id}/export", server.ExportSessionHttpHandler).Methods("GET")
+	r.HandleFunc("/api/session/{id}/backlog", server.GetBacklogHttpHandler).Methods("GET")
+	r.HandleFunc("/api/session/{id}/backlog", server.QueueStoriesHttpHandler).Methods("POST")
+	r.HandleFunc("/api/session/{id}/tracker", server.SetTrackerHttpHandler).Methods("PUT")
+	r.HandleFunc("/api/session/{id}/tracker/import", server.ImportIssuesHttpHandler).Methods("POST")
 	r.HandleFunc("/api/user/{id}", server.GetUserHttpHandler).Methods("GET")
 	r.HandleFunc("/api/user", server.CreateUserHttpHandler).Methods("POST")
 	r.HandleFunc("/api/vote/start", server.StartVoteHttpHandler).Methods("PUT")
 	r.HandleFunc("/api/vote/finish", server.FinishVoteHttpHandler).Methods("PUT")
 	r.HandleFunc("/api/vote/cast", server.CastVoteHttpHandler).Methods("PUT")
+	r.HandleFunc("/api/vote/reopen", server.ReopenVoteHttpHandler).Methods("PUT")
+	r.HandleFunc("/api/vote/accept", server.AcceptEstimateHttpHandler).Methods("PUT")
+	if config.Features.SlashCommands {
+		r.HandleFunc("/api/slash", server.SlashCommandHttpHandler).Methods("POST")
+	}
+	r.Handle(socketRoute, server.service.Hub().WebSocketHandler())
+	if config.Features.Metrics {
+		r.Handle("/metrics", promhttp.Handler()).Methods("GET")
+	}
 
-	spa := spaHandler{staticPath: "../ballot-ui/dist", indexPath: "index.html"}
-	r.PathPrefix("/").Handler(spa)
+	if config.Features.Ui {
+		r.PathPrefix("/").Handler(uiHandler(config))
+	}
 
-	http.Handle("/", r)
+	// routes are declared without the base path, which is stripped before routing
+	var handler http.Handler = r
+	if o.basePath != "" {
+		handler = http.StripPrefix(o.basePath, handler)
+	}
 
-	server.service.Hub().HandleWebSockets("/glue/ws")
+	for i := len(o.middleware) - 1; i >= 0; i-- {
+		handler = o.middleware[i](handler)
+	}
+	server.handler = handler
 
 	return server
 }
 
+// uiHandler serves the UI built into the binary, or the one in StaticPath, which is picked up without a restart
+func uiHandler(config config.Config) http.Handler {
+	var build fs.FS
+	live := config.StaticPath != ""
+	if live {
+		slog.Info("Serving the UI from disk", "path", config.StaticPath)
+		build = os.DirFS(config.StaticPath)
+	} else {
+		build = ui.Build()
+	}
+
+	handler, err := ui.NewHandler(build, live)
+	if err != nil {
+		panic(fmt.Sprintf("Could not load the UI: %+v", err))
+	}
+	return handler
+}
+
+func (p server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.handler.ServeHTTP(w, r)
+}
+
 func (p server) Service() *service.Service {
 	return p.service
 }
 
 func (p server) Release() {
-	log.Print("Releasing server resources")
+	slog.Info("Releasing server resources")
 	p.service.Release()
-	log.Print("Server done")
+	slog.Info("Server done")
 }
 
+// writeError answers with the API error in err. Anything else is logged, and answered with the message:
+// a 504 when Redis is too slow to answer, a 503 when it cannot be reached, and a 500 otherwise.
+func writeError(w http.ResponseWriter, r *http.Request, err error, message string) {
+	apiErr, ok := errors.As(err)
+	if !ok {
+		logutil.Error(r.Context(), err)
+
+		code := errors.Internal
+		switch {
+		case db.IsTimeout(err):
+			code = errors.Timeout
+		case db.IsUnavailable(err):
+			code = errors.Unavailable
+		}
+		apiErr = errors.Error{Code: code, Message: message}
+	}
+
+	var data, _ = json.Marshal(apiErr)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(apiErr.Status())
+	logutil.Logger(fmt.Fprintf(w, "%s", data))
+}
+
+// readRequest decodes the JSON request body, or answers with a 400
+func readRequest(w http.ResponseWriter, r *http.Request, reqObj interface{}) bool {
+	reqBody, err := jsonutil.GetRequestBody(r)
+	if err == nil {
+		err = json.Unmarshal([]byte(reqBody), reqObj)
+	}
+	if err != nil {
+		slog.InfoContext(r.Context(), "Invalid request", logutil.Err(err))
+		writeError(w, r, errors.Error{Code: errors.InvalidRequest, Message: "Request body is not valid JSON"}, "")
+		return false
+	}
+	return true
+}
+
+// HealthHttpHandler is the liveness check: the process is up and serving requests
 func (p server) HealthHttpHandler(w http.ResponseWriter, _ *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	var health = response.HealthResponse{Status: "OK"}
+	var health = response.HealthResponse{Status: response.HealthOk}
 	var data, _ = json.Marshal(health)
 
 	logutil.Logger(fmt.Fprintf(w, "%s", data))
 }
 
-func (p server) CreateSessionHttpHandler(w http.ResponseWriter, _ *http.Request) {
+// ReadinessHttpHandler answers 503 when Redis, a subscriber connection or the socket server is down,
+// so that the instance is taken out of rotation until it recovers
+func (p server) ReadinessHttpHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+
+	readiness := response.ReadinessResponse{
+		Status:     response.HealthOk,
+		Components: map[string]response.ComponentHealth{},
+	}
+	for name, err := range p.service.Status(r.Context()) {
+		if err == nil {
+			readiness.Components[name] = response.ComponentHealth{Status: response.HealthOk}
+			continue
+		}
+		slog.WarnContext(r.Context(), "Not ready", "component", name, logutil.Err(err))
+		readiness.Status = response.HealthDegraded
+		readiness.Components[name] = response.ComponentHealth{Status: response.HealthDown, Error: err.Error()}
+	}
+
+	var data, _ = json.Marshal(readiness)
+	if readiness.Status != response.HealthOk {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	logutil.Logger(fmt.Fprintf(w, "%s", data))
+}
 
-	session, err := p.service.CreateSession()
+// maxBacklogSize is the largest backlog file taken
+const maxBacklogSize = 1 << 20
+
+// readBacklog parses the backlog in the request body, or answers with a 400. An empty body is no backlog.
+func readBacklog(w http.ResponseWriter, r *http.Request) ([]model.Story, bool) {
+	// a request built by hand, rather than read by the server, can have no body at all
+	if r.Body == nil {
+		return nil, true
+	}
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBacklogSize))
 	if err != nil {
-		log.Printf("%+v", err)
-		err = errors.CriticalError{Message: "Error saving data"}
-		var data, _ = json.Marshal(err)
-		http.Error(w, string(data), http.StatusInternalServerError)
+		slog.InfoContext(r.Context(), "Invalid backlog", logutil.Err(err))
+		writeError(w, r, errors.Error{Code: errors.InvalidRequest, Message: "The backlog could not be read, or is over 1 MB"}, "")
+		return nil, false
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil, true
+	}
+
+	stories, err := backlog.Parse(r.Header.Get("Content-Type"), data)
+	if err != nil {
+		writeError(w, r, err, "")
+		return nil, false
+	}
+	return stories, true
+}
+
+// CreateSessionHttpHandler creates a session. The body, if there is one, is a backlog to queue in the session.
+func (p server) CreateSessionHttpHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	stories, ok := readBacklog(w, r)
+	if !ok {
 		return
 	}
 
+	session, err := p.service.CreateSession(r.Context())
+	if err != nil {
+		writeError(w, r, err, "Error saving data")
+		return
+	}
+
+	if len(stories) > 0 {
+		_, err = p.service.QueueStories(r.Context(), session.SessionId, stories)
+		if err != nil {
+			writeError(w, r, err, "Error saving data")
+			return
+		}
+	}
+
 	var data, _ = json.Marshal(session)
-	log.Printf("API session with %+v", session)
 	logutil.Logger(fmt.Fprintf(w, "%s", data))
 }
 
+// GetSessionHttpHandler answers with the same snapshot a socket gets on WATCH.
+// HEAD tells whether the session is still there.
+func (p server) GetSessionHttpHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+
+	vars := mux.Vars(r)
+	session, err := p.service.GetSession(r.Context(), vars["id"])
+	if err != nil {
+		writeError(w, r, err, "Error getting session")
+		return
+	}
+
+	data, _ := json.Marshal(session)
+	logutil.Logger(fmt.Fprintf(w, "%s", data))
+}
+
+// CloseSessionHttpHandler closes the session for everyone, and deletes it. The user_id query parameter
+// is the facilitator closing it.
+func (p server) CloseSessionHttpHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userId := r.URL.Query().Get("user_id")
+	if userId == "" {
+		writeError(w, r, errors.Error{Code: errors.Validation, Field: "user_id", Message: "This field cannot be empty"}, "")
+		return
+	}
+
+	vars := mux.Vars(r)
+	err := p.service.CloseSession(r.Context(), vars["id"], userId)
+	if err != nil {
+		writeError(w, r, err, "Error closing session")
+		return
+	}
+
+	logutil.Logger(fmt.Fprint(w, "{}"))
+}
+
+// QueueStoriesHttpHandler adds the stories of a CSV or JSON backlog to the end of the session backlog
+func (p server) QueueStoriesHttpHandler(w http.ResponseWriter, r *http.Request) {
+	stories, ok := readBacklog(w, r)
+	if !ok {
+		return
+	}
+	if len(stories) == 0 {
+		writeError(w, r, errors.Error{Code: errors.Validation, Message: "The backlog has no stories"}, "")
+		return
+	}
+
+	vars := mux.Vars(r)
+	queued, err := p.service.QueueStories(r.Context(), vars["id"], stories)
+	if err != nil {
+		writeError(w, r, err, "Error saving data")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	data, _ := json.Marshal(response.BacklogResponse{Stories: queued})
+	logutil.Logger(fmt.Fprintf(w, "%s", data))
+}
+
+// GetBacklogHttpHandler answers with the stories still to be estimated
+func (p server) GetBacklogHttpHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+
+	vars := mux.Vars(r)
+	stories, err := p.service.GetBacklog(r.Context(), vars["id"])
+	if err != nil {
+		writeError(w, r, err, "Error getting backlog")
+		return
+	}
+
+	data, _ := json.Marshal(response.BacklogResponse{Stories: stories})
+	logutil.Logger(fmt.Fprintf(w, "%s", data))
+}
+
+// SetTrackerHttpHandler connects the session to an issue tracker, and answers with the settings, without the token
+func (p server) SetTrackerHttpHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var reqObj request.SetTrackerRequest
+	if !readRequest(w, r, &reqObj) {
+		return
+	}
+
+	vars := mux.Vars(r)
+	settings, err := p.service.SetTracker(r.Context(), vars["id"], reqObj.UserId, model.Tracker{
+		Kind:  reqObj.Kind,
+		Repo:  reqObj.Repo,
+		Token: reqObj.Token,
+		Label: reqObj.Label,
+	})
+	if err != nil {
+		writeError(w, r, err, "Error connecting issue tracker")
+		return
+	}
+
+	data, _ := json.Marshal(settings)
+	logutil.Logger(fmt.Fprintf(w, "%s", data))
+}
+
+// ImportIssuesHttpHandler queues the open issues of the session's issue tracker that match the query
+func (p server) ImportIssuesHttpHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var reqObj request.ImportIssuesRequest
+	if !readRequest(w, r, &reqObj) {
+		return
+	}
+
+	vars := mux.Vars(r)
+	queued, err := p.service.ImportIssues(r.Context(), vars["id"], reqObj.UserId, reqObj.Query)
+	if err != nil {
+		writeError(w, r, err, "Error importing issues")
+		return
+	}
+
+	data, _ := json.Marshal(response.BacklogResponse{Stories: queued})
+	logutil.Logger(fmt.Fprintf(w, "%s", data))
+}
+
+// ExportSessionHttpHandler answers with the participants and the finished rounds of the session, as a
+// json (the default), csv or md file
+func (p server) ExportSessionHttpHandler(w http.ResponseWriter, r *http.Request) {
+	formatName := r.URL.Query().Get("format")
+	if formatName == "" {
+		formatName = "json"
+	}
+	format, ok := export.Formats[formatName]
+	if !ok {
+		writeError(w, r, errors.Error{Code: errors.Validation, Field: "format", Message: "Format must be json, csv or md"}, "")
+		return
+	}
+
+	vars := mux.Vars(r)
+	sessionExport, err := p.service.ExportSession(r.Context(), vars["id"])
+	if err != nil {
+		writeError(w, r, err, "Error exporting session")
+		return
+	}
+
+	var buf bytes.Buffer
+	err = format.Write(&buf, sessionExport)
+	if err != nil {
+		writeError(w, r, err, "Error exporting session")
+		return
+	}
+
+	w.Header().Set("Content-Type", format.ContentType)
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Disposition",
+		fmt.Sprintf(`attachment; filename="ballot-%s.%s"`, sessionExport.SessionId, format.Extension))
+	logutil.Logger(w.Write(buf.Bytes()))
+}
+
 func (p server) StartVoteHttpHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	reqBody, err := jsonutil.GetRequestBody(r)
 	var reqObj request.StartVoteRequest
-	err = json.Unmarshal([]byte(reqBody), &reqObj)
-	if err != nil {
-		log.Printf("%+v", err)
-		err = errors.CriticalError{Message: "Error serializing request JSON"}
-		var data, _ = json.Marshal(err)
-		http.Error(w, string(data), http.StatusInternalServerError)
+	if !readRequest(w, r, &reqObj) {
 		return
 	}
 
-	err = p.service.StartVote(reqObj.SessionId)
+	err := p.service.StartVote(r.Context(), reqObj.SessionId)
 	if err != nil {
-		log.Printf("%+v", err)
-		err = errors.CriticalError{Message: "Error starting vote"}
-		var data, _ = json.Marshal(err)
-		http.Error(w, string(data), http.StatusInternalServerError)
+		writeError(w, r, err, "Error starting vote")
 		return
 	}
 
@@ -162,23 +476,32 @@ func (p server) StartVoteHttpHandler(w http.ResponseWriter, r *http.Request) {
 func (p server) FinishVoteHttpHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	reqBody, err := jsonutil.GetRequestBody(r)
-	var reqObj request.StartVoteRequest
-	err = json.Unmarshal([]byte(reqBody), &reqObj)
+	var reqObj request.FinishVoteRequest
+	if !readRequest(w, r, &reqObj) {
+		return
+	}
+
+	err := p.service.FinishVote(r.Context(), reqObj.SessionId)
 	if err != nil {
-		log.Printf("%+v", err)
-		err = errors.CriticalError{Message: "Error serializing request JSON"}
-		var data, _ = json.Marshal(err)
-		http.Error(w, string(data), http.StatusInternalServerError)
+		writeError(w, r, err, "Error finishing vote")
 		return
 	}
 
-	err = p.service.FinishVote(reqObj.SessionId)
+	logutil.Logger(fmt.Fprint(w, "{}"))
+}
+
+// ReopenVoteHttpHandler puts a finished vote back into voting, keeping the votes cast
+func (p server) ReopenVoteHttpHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var reqObj request.ReopenVoteRequest
+	if !readRequest(w, r, &reqObj) {
+		return
+	}
+
+	err := p.service.ReopenVote(r.Context(), reqObj.SessionId, reqObj.UserId)
 	if err != nil {
-		log.Printf("%+v", err)
-		err = errors.CriticalError{Message: "Error finishing vote"}
-		var data, _ = json.Marshal(err)
-		http.Error(w, string(data), http.StatusInternalServerError)
+		writeError(w, r, err, "Error reopening vote")
 		return
 	}
 
@@ -188,65 +511,58 @@ func (p server) FinishVoteHttpHandler(w http.ResponseWriter, r *http.Request) {
 func (p server) CastVoteHttpHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	reqBody, err := jsonutil.GetRequestBody(r)
 	var reqObj request.CastVoteRequest
-	err = json.Unmarshal([]byte(reqBody), &reqObj)
+	if !readRequest(w, r, &reqObj) {
+		return
+	}
+
+	vote, err := p.service.CastVote(r.Context(), reqObj.SessionId, reqObj.UserId, reqObj.Estimate)
 
 	if err != nil {
-		log.Printf("%+v", err)
-		err = errors.CriticalError{Message: "Error serializing request JSON"}
-		var data, _ = json.Marshal(err)
-		http.Error(w, string(data), http.StatusInternalServerError)
+		writeError(w, r, err, "Error casting vote")
 		return
 	}
 
-	vote, err := p.service.CastVote(reqObj.SessionId, reqObj.UserId, reqObj.Estimate)
+	data, _ := json.Marshal(vote)
+	logutil.Logger(fmt.Fprintf(w, "%s", data))
+}
+
+// AcceptEstimateHttpHandler settles the estimate of the last round, and answers with the round
+func (p server) AcceptEstimateHttpHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var reqObj request.AcceptEstimateRequest
+	if !readRequest(w, r, &reqObj) {
+		return
+	}
 
+	round, err := p.service.AcceptEstimate(r.Context(), reqObj.SessionId, reqObj.UserId, reqObj.Estimate)
 	if err != nil {
-		log.Printf("%+v", err)
-		err = errors.CriticalError{Message: "Error casting vote"}
-		var data, _ = json.Marshal(err)
-		http.Error(w, string(data), http.StatusInternalServerError)
+		writeError(w, r, err, "Error accepting estimate")
 		return
 	}
 
-	data, _ := json.Marshal(vote)
+	data, _ := json.Marshal(round)
 	logutil.Logger(fmt.Fprintf(w, "%s", data))
 }
 
 func (p server) CreateUserHttpHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	reqBody, err := jsonutil.GetRequestBody(r)
 	var reqObj request.CreateUserRequest
-	err = json.Unmarshal([]byte(reqBody), &reqObj)
-
-	if err != nil {
-		log.Printf("%+v", err)
-		err = errors.CriticalError{Message: "Error serializing request JSON"}
-		var data, _ = json.Marshal(err)
-		http.Error(w, string(data), http.StatusInternalServerError)
+	if !readRequest(w, r, &reqObj) {
 		return
 	}
 
-	var user model.User
-	user, err = p.service.CreateUser(
+	user, err := p.service.CreateUser(
+		r.Context(),
 		reqObj.SessionId,
 		reqObj.UserName,
 		reqObj.IsAdmin == 1,
 		reqObj.IsObserver == 1)
 
 	if err != nil {
-		log.Printf("%+v", errorx.EnsureStackTrace(err))
-
-		switch err.(type) {
-		case errors.ValidationError:
-			data, _ := json.Marshal(err)
-			http.Error(w, string(data), http.StatusBadRequest)
-		default:
-			http.Error(w, "{}", http.StatusInternalServerError)
-		}
-
+		writeError(w, r, err, "Error creating user")
 		return
 	}
 
@@ -261,16 +577,80 @@ func (p server) GetUserHttpHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	userId := vars["id"]
 
-	user, err := p.service.GetUser(userId)
+	user, err := p.service.GetUser(r.Context(), userId)
 
 	if err != nil {
-		log.Printf("%+v", err)
-		err = errors.CriticalError{Message: "Error creating user"}
-		var data, _ = json.Marshal(err)
-		http.Error(w, string(data), http.StatusInternalServerError)
+		writeError(w, r, err, "Error getting user")
 		return
 	}
 
 	data, _ := json.Marshal(user)
 	logutil.Logger(fmt.Fprintf(w, "%s", data))
 }
+
+func (p server) SlashCommandHttpHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	reqBody, err := jsonutil.GetRequestBody(r)
+	if err != nil {
+		writeError(w, r, err, "Error reading request")
+		return
+	}
+
+	conf := p.service.Config()
+	err = slash.Verify(conf.SlashSigningSecret, conf.SlashToken, r.Header, []byte(reqBody), time.Now())
+	if err != nil {
+		slog.WarnContext(r.Context(), "Rejected slash command", logutil.Err(err))
+		writeError(w, r, errors.Error{Code: errors.Unauthorized, Message: "Invalid slash command signature"}, "")
+		return
+	}
+
+	form, err := url.ParseQuery(reqBody)
+	if err != nil {
+		slog.InfoContext(r.Context(), "Invalid slash command", logutil.Err(err))
+		writeError(w, r, errors.Error{Code: errors.InvalidRequest, Message: "Error parsing slash command"}, "")
+		return
+	}
+
+	command := slash.ParseCommand(form)
+	action, title := slash.SplitText(command.Text)
+	slog.InfoContext(r.Context(), "Slash command", "action", action, logutil.UserName(command.UserName))
+
+	var msg slash.Message
+
+	switch action {
+	case slash.Action.New:
+		session, err := p.service.CreateSession(r.Context())
+		if err != nil {
+			logutil.Error(r.Context(), err)
+			msg = slash.Message{ResponseType: slash.Ephemeral, Text: "Error creating a session"}
+			break
+		}
+
+		if title != "" {
+			err = p.service.SetSessionTitle(r.Context(), session.SessionId, title)
+			if err != nil {
+				logutil.Error(r.Context(), err)
+			}
+		}
+
+		if command.ResponseUrl != "" {
+			err = p.service.SetSessionResponseUrl(r.Context(), session.SessionId, command.ResponseUrl)
+			if err != nil {
+				logutil.Error(r.Context(), err)
+			}
+		}
+
+		link := fmt.Sprintf("%s/vote/s/%s", conf.HttpHost, session.SessionId)
+		text := fmt.Sprintf("Estimation session started: %s", link)
+		if title != "" {
+			text = fmt.Sprintf("Estimation session for *%s*: %s", title, link)
+		}
+		msg = slash.Message{ResponseType: slash.InChannel, Text: text}
+	default:
+		msg = slash.Message{ResponseType: slash.Ephemeral, Text: slash.Usage(command.Command)}
+	}
+
+	data, _ := json.Marshal(msg)
+	logutil.Logger(fmt.Fprintf(w, "%s", data))
+}
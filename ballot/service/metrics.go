@@ -0,0 +1,53 @@
+package service
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	sessionsCreated = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "ballot_sessions_created_total",
+		Help: "Sessions created.",
+	})
+
+	sessionsClosed = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "ballot_sessions_closed_total",
+		Help: "Sessions closed and purged by a facilitator.",
+	})
+
+	sessionsExpired = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "ballot_sessions_expired_total",
+		Help: "Idle sessions that expired.",
+	})
+
+	votesCast = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "ballot_votes_cast_total",
+		Help: "Votes cast, including changed votes.",
+	})
+
+	roundsStarted = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "ballot_rounds_started_total",
+		Help: "Voting rounds started.",
+	})
+
+	roundsFinished = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "ballot_rounds_finished_total",
+		Help: "Voting rounds finished, by everyone voting or by an admin.",
+	})
+
+	roundsReopened = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "ballot_rounds_reopened_total",
+		Help: "Finished voting rounds reopened, keeping the votes cast.",
+	})
+
+	estimatesAccepted = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "ballot_estimates_accepted_total",
+		Help: "Estimates accepted by a facilitator, in place of the tally.",
+	})
+
+	estimatesSynced = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ballot_estimates_synced_total",
+		Help: "Estimates written back to issue trackers, by result: ok or failed.",
+	}, []string{"result"})
+)
@@ -1,22 +1,29 @@
 package service
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/gomodule/redigo/redis"
 	"github.com/google/uuid"
 	"github.com/joomcode/errorx"
+	"github.com/papito/ballot/ballot/backlog"
 	"github.com/papito/ballot/ballot/config"
 	"github.com/papito/ballot/ballot/db"
 	"github.com/papito/ballot/ballot/errors"
 	. "github.com/papito/ballot/ballot/hub"
 	"github.com/papito/ballot/ballot/jsonutil"
+	"github.com/papito/ballot/ballot/logutil"
 	"github.com/papito/ballot/ballot/model"
 	"github.com/papito/ballot/ballot/model/response"
-	"log"
+	"github.com/papito/ballot/ballot/slash"
+	"github.com/papito/ballot/ballot/tracker"
+	"log/slog"
+	"regexp"
 	"sort"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
@@ -24,14 +31,20 @@ type Service struct {
 	store  *db.Store
 	hub    IHub
 	config config.Config
+
+	// closed on release, to stop the subscriber loop from reconnecting
+	done chan struct{}
+
+	// whether the subscriber has a working connection. A pointer, since the service is passed around by value.
+	subscribed *atomic.Bool
 }
 
-func getHub(config config.Config) IHub {
+func getHub(conf config.Config) IHub {
 	var hubImpl IHub = nil
-	if config.Environment == "test" {
+	if conf.Broker == config.BrokerNone {
 		hubImpl = &VoidHub{}
 	} else {
-		hubImpl = &Hub{}
+		hubImpl = &Hub{AllowedOrigins: conf.AllowedOrigins}
 	}
 
 	return hubImpl
@@ -40,47 +53,118 @@ func getHub(config config.Config) IHub {
 func NewService(config config.Config) Service {
 	hubImpl := getHub(config)
 	service := Service{
-		store:  &db.Store{},
-		hub:    hubImpl,
-		config: config,
+		store:      &db.Store{},
+		hub:        hubImpl,
+		config:     config,
+		done:       make(chan struct{}),
+		subscribed: &atomic.Bool{},
 	}
 
-	service.store.Pool = db.NewPool(config.RedisUrl)
+	// config.Validate catches this at startup, with a friendlier error
+	pool, err := db.NewPool(config)
+	if err != nil {
+		panic(fmt.Sprintf("Invalid Redis settings: %v", err))
+	}
+	service.store.Pool = pool
+	service.store.Timeout = config.RedisTimeout
+	service.store.SessionTtl = config.SessionTtl
+
+	/* Initiate the hub that connects sessions and sockets. The subscriber below asks it for its sessions.
+	 */
+	slog.Debug("Creating hub")
+	service.hub.Connect(service.store)
 
 	go func() {
 		for {
-			service.store.ServiceSubCon = redis.PubSubConn{Conn: service.store.Pool.Get()}
+			err := service.resubscribe()
+			if err != nil {
+				slog.Warn("Service subscriber could not subscribe", logutil.Err(err))
+				_ = service.store.ServiceSubCon.Close()
+			}
+			// not ready until every session is subscribed again, or users leaving would not be removed
+			service.subscribed.Store(err == nil)
 
-			for service.store.ServiceSubCon.Conn.Err() == nil {
+			for service.store.ServiceSubCon.Err() == nil {
 				switch v := service.store.ServiceSubCon.Receive().(type) {
 				case redis.Message:
-					log.Printf(
-						"Service subscriber connection received [%s] on channel [%s]", v.Data, v.Channel)
+					slog.Debug("Service subscriber received", logutil.SessionIdKey, v.Channel, logutil.Event(string(v.Data)))
 					service.processSubscriberEvent(v.Channel, string(v.Data))
+				case redis.Subscription:
+					if v.Count == 0 && service.isReleased() {
+						_ = service.store.ServiceSubCon.Close()
+						slog.Info("Service subscriber connection closed")
+						return
+					}
 				case error:
-					log.Print("PubSub err...or?")
-					fmt.Printf(service.store.ServiceSubCon.Conn.Err().Error())
+					slog.Warn("Service subscriber connection failed", logutil.Err(v))
 				}
 			}
+			service.subscribed.Store(false)
 			_ = service.store.ServiceSubCon.Close()
 
-			log.Print("Heroically getting a new connection!")
-			service.store.ServiceSubCon = redis.PubSubConn{Conn: service.store.Pool.Get()}
+			if service.isReleased() {
+				slog.Info("Service subscriber connection closed")
+				return
+			}
+
+			// the new connection is taken at the top of the loop
+			slog.Warn("Service subscriber reconnecting")
+			db.PubSubReconnects.WithLabelValues("service").Inc()
+			select {
+			case <-service.done:
+			case <-time.After(db.ReconnectDelay):
+			}
 		}
 	}()
 
-	/* Initiate the hub that connects sessions and sockets
-	 */
-	log.Println("Creating hub")
-	service.hub.Connect(service.store)
+	go service.sweep()
 
 	return service
 }
 
+// resubscribe takes a new connection for the subscriber, subscribed to the sessions with sockets on this instance,
+// since a lost connection takes its subscriptions with it. The hub subscribes new sessions itself, and swaps the
+// connection under its lock, so that sessions joined or closed meanwhile are not missed.
+func (p *Service) resubscribe() error {
+	return p.hub.Resubscribe(&p.store.ServiceSubCon)
+}
+
 func (p *Service) Release() {
-	log.Print("Releasing service resources")
+	slog.Info("Releasing service resources")
 	p.hub.Release()
-	log.Print("Service done")
+
+	// same as the hub - unsubscribing lets the subscriber goroutine close its connection
+	close(p.done)
+	err := p.store.ServiceSubCon.Unsubscribe()
+	if err != nil {
+		logutil.Error(context.Background(), errorx.EnsureStackTrace(err))
+	}
+
+	err = p.store.Pool.Close()
+	if err != nil {
+		logutil.Error(context.Background(), errorx.EnsureStackTrace(err))
+	}
+	slog.Info("Service done")
+}
+
+func (p *Service) isReleased() bool {
+	select {
+	case <-p.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Status has the health of Redis, of both subscriber connections and of the socket server, nil for what is fine
+func (p *Service) Status(ctx context.Context) map[string]error {
+	status := p.hub.Status()
+	status["redis"] = p.store.Ping(ctx)
+	status["service_subscriber"] = nil
+	if !p.subscribed.Load() {
+		status["service_subscriber"] = ErrNotSubscribed
+	}
+	return status
 }
 
 func (p *Service) Hub() IHub {
@@ -95,50 +179,154 @@ func (p *Service) Store() *db.Store {
 	return p.store
 }
 
-func (p *Service) CreateSession() (model.Session, error) {
+func (p *Service) CreateSession(ctx context.Context) (model.Session, error) {
 	sessionUUID, _ := uuid.NewRandom()
 	sessionId := sessionUUID.String()
 	session := model.Session{SessionId: sessionId}
+	ctx = logutil.WithSessionId(ctx, sessionId)
+
+	batch := p.store.NewBatch()
+	batch.Set(fmt.Sprintf(db.Const.SessionState, sessionId), model.NotVoting)
+	batch.Set(fmt.Sprintf(db.Const.VoteCount, sessionId), 0)
+	batch.TrackSession(sessionId)
 
-	key := fmt.Sprintf(db.Const.SessionState, sessionId)
-	err := p.store.Set(key, model.NotVoting)
+	err := p.store.Exec(ctx, batch)
 	if err != nil {
-		log.Printf("%+v", err)
+		logutil.Error(ctx, err)
 		return model.Session{}, err
 	}
+	sessionsCreated.Inc()
+	slog.InfoContext(ctx, "Created session")
 
-	key = fmt.Sprintf(db.Const.VoteCount, sessionId)
-	err = p.store.Set(key, 0)
+	return session, nil
+}
+
+// CloseSession tells everyone in the session that it is over, and deletes it along with its users.
+// Only a facilitator of the session may close it.
+func (p *Service) CloseSession(ctx context.Context, sessionId string, userId string) error {
+	ctx = logutil.WithUserId(logutil.WithSessionId(ctx, sessionId), userId)
+	_, err := p.sessionState(ctx, sessionId)
 	if err != nil {
-		log.Printf("%+v", err)
-		return model.Session{}, err
+		return err
 	}
 
-	return session, nil
+	err = p.checkFacilitator(ctx, sessionId, userId, "Only a facilitator of the session can close it")
+	if err != nil {
+		return err
+	}
+
+	closed := response.WsSessionClosed{
+		Event:     response.SessionClosedEvent,
+		SessionId: sessionId,
+	}
+	data, err := json.Marshal(closed)
+	if err != nil {
+		logutil.Error(ctx, errorx.EnsureStackTrace(err))
+		return errorx.EnsureStackTrace(err)
+	}
+
+	// the hubs disconnect the sockets when they get the event
+	err = p.hub.Emit(ctx, sessionId, string(data))
+	if err != nil {
+		logutil.Error(ctx, err)
+		return err
+	}
+
+	err = p.store.DeleteSession(ctx, sessionId)
+	if err != nil {
+		logutil.Error(ctx, err)
+		return err
+	}
+	sessionsClosed.Inc()
+	slog.InfoContext(ctx, "Closed session")
+
+	return nil
+}
+
+// checkFacilitator is a forbidden error, with the message, unless the user is a facilitator in the session
+func (p *Service) checkFacilitator(ctx context.Context, sessionId string, userId string, message string) error {
+	user, err := p.GetUser(ctx, userId)
+	if err != nil {
+		return err
+	}
+	inSession, err := p.isInSession(ctx, sessionId, user)
+	if err != nil {
+		return err
+	}
+	if !user.IsAdmin || !inSession {
+		return errors.Error{Code: errors.Forbidden, Message: message}
+	}
+	return nil
+}
+
+// isInSession is whether the user has joined the session, as a voter or as an observer
+func (p *Service) isInSession(ctx context.Context, sessionId string, user model.User) (bool, error) {
+	key := fmt.Sprintf(db.Const.SessionUsers, sessionId)
+	if user.IsObserver {
+		key = fmt.Sprintf(db.Const.SessionObservers, sessionId)
+	}
+
+	isMember, err := p.store.IsMember(ctx, key, user.UserId)
+	if err != nil {
+		logutil.Error(ctx, err)
+		return false, err
+	}
+	return isMember, nil
+}
+
+func (p *Service) SetSessionTitle(ctx context.Context, sessionId string, title string) error {
+	ctx = logutil.WithSessionId(ctx, sessionId)
+	batch := p.store.NewBatch().Set(fmt.Sprintf(db.Const.Title, sessionId), title).Touch(sessionId)
+	err := p.store.Exec(ctx, batch)
+	if err != nil {
+		logutil.Error(ctx, err)
+		return err
+	}
+	return nil
+}
+
+// SetSessionResponseUrl registers the slash command response URL the final tally is posted to
+func (p *Service) SetSessionResponseUrl(ctx context.Context, sessionId string, responseUrl string) error {
+	ctx = logutil.WithSessionId(ctx, sessionId)
+	key := fmt.Sprintf(db.Const.ResponseUrl, sessionId)
+	err := p.store.Set(ctx, key, responseUrl)
+	if err != nil {
+		logutil.Error(ctx, err)
+		return err
+	}
+	return nil
 }
 
-func (p *Service) CreateUser(sessionId string, userName string, isAdmin bool, isObserver bool) (model.User, error) {
+func (p *Service) CreateUser(ctx context.Context, sessionId string, userName string, isAdmin bool, isObserver bool) (model.User, error) {
+	ctx = logutil.WithSessionId(ctx, sessionId)
 	userName = strings.TrimSpace(userName)
 
 	if len(userName) < 1 {
-		valErr := errors.ValidationError{
-			Field:    "user.name",
-			ErrorStr: "This field cannot be empty"}
+		valErr := errors.Error{
+			Code:    errors.Validation,
+			Field:   "user.name",
+			Message: "This field cannot be empty"}
 		return model.User{}, valErr
 	}
 
+	_, err := p.sessionState(ctx, sessionId)
+	if err != nil {
+		return model.User{}, err
+	}
+
 	// check for a duplicate user in this session
-	currentUsers, err := p.store.GetSessionVoters(sessionId)
+	currentUsers, err := p.store.GetSessionVoters(ctx, sessionId)
 	if err != nil {
-		log.Printf("%+v", err)
+		logutil.Error(ctx, err)
 		return model.User{}, err
 	}
 
 	for _, user := range currentUsers {
 		if strings.ToLower(user.Name) == strings.ToLower(userName) {
-			valErr := errors.ValidationError{
-				Field:    "user.name",
-				ErrorStr: "This user name already taken for this session"}
+			valErr := errors.Error{
+				Code:    errors.Conflict,
+				Field:   "user.name",
+				Message: "This user name already taken for this session"}
 			return model.User{}, valErr
 		}
 	}
@@ -146,7 +334,8 @@ func (p *Service) CreateUser(sessionId string, userName string, isAdmin bool, is
 	userUUID, _ := uuid.NewRandom()
 	userId := userUUID.String()
 	joined := strconv.FormatInt(time.Now().UTC().UnixNano(), 10)
-	log.Printf("Creating user [%s] and id [%s]", userName, userId)
+	ctx = logutil.WithUserId(ctx, userId)
+	slog.InfoContext(ctx, "Creating user", logutil.UserName(userName), "is_admin", isAdmin, "is_observer", isObserver)
 
 	user := model.User{
 		UserId:     userId,
@@ -158,7 +347,7 @@ func (p *Service) CreateUser(sessionId string, userName string, isAdmin bool, is
 	}
 
 	userKey := fmt.Sprintf(db.Const.User, userId)
-	err = p.store.SetHashKey(
+	batch := p.store.NewBatch().SetHashKey(
 		userKey,
 		"name", user.Name,
 		"id", user.UserId,
@@ -166,19 +355,25 @@ func (p *Service) CreateUser(sessionId string, userName string, isAdmin bool, is
 		"joined", user.Joined,
 		"is_admin", user.IsAdmin,
 		"is_observer", isObserver)
+	// everyone who was ever in the session, for purging it
+	batch.AddToSet(fmt.Sprintf(db.Const.SessionMembers, sessionId), userId)
+	batch.Touch(sessionId)
+
+	err = p.store.Exec(ctx, batch)
 
 	if err != nil {
-		log.Printf("%+v", err)
+		logutil.Error(ctx, err)
 		return model.User{}, err
 	}
 
 	return user, nil
 }
 
-func (p *Service) AddUserToSession(sessionId string, userId string) error {
+func (p *Service) AddUserToSession(ctx context.Context, sessionId string, userId string) error {
+	ctx = logutil.WithUserId(logutil.WithSessionId(ctx, sessionId), userId)
 	sessionUserKey := fmt.Sprintf(db.Const.SessionUsers, sessionId)
-	log.Printf("Adding user [%s] to session [%s]", userId, sessionId)
-	err := p.store.AddToSet(sessionUserKey, userId)
+	slog.InfoContext(ctx, "Adding user to session")
+	err := p.store.AddToSet(ctx, sessionUserKey, userId)
 	if err != nil {
 		return err
 	}
@@ -186,75 +381,405 @@ func (p *Service) AddUserToSession(sessionId string, userId string) error {
 	return nil
 }
 
-func (p *Service) RemoveUserFromSession(sessionId string, userId string) error {
-	log.Printf("Removing user [%s] from session [%s]", userId, sessionId)
+func (p *Service) RemoveUserFromSession(ctx context.Context, sessionId string, userId string) error {
+	ctx = logutil.WithUserId(logutil.WithSessionId(ctx, sessionId), userId)
+	slog.InfoContext(ctx, "Removing user from session")
 
 	sessionUserKey := fmt.Sprintf(db.Const.SessionUsers, sessionId)
-	err := p.store.RemoveFromSet(sessionUserKey, userId)
+	err := p.store.RemoveFromSet(ctx, sessionUserKey, userId)
 	if err != nil {
-		log.Printf("%+v", err)
+		logutil.Error(ctx, err)
 		return err
 	}
 	return nil
 }
 
-func (p *Service) RemoveObserver(sessionId string, userId string) error {
-	log.Printf("Removing observer [%s] from session [%s]", userId, sessionId)
+func (p *Service) RemoveObserver(ctx context.Context, sessionId string, userId string) error {
+	ctx = logutil.WithUserId(logutil.WithSessionId(ctx, sessionId), userId)
+	slog.InfoContext(ctx, "Removing observer from session")
 
 	sessionObserverKey := fmt.Sprintf(db.Const.SessionObservers, sessionId)
-	err := p.store.RemoveFromSet(sessionObserverKey, userId)
+	err := p.store.RemoveFromSet(ctx, sessionObserverKey, userId)
 	if err != nil {
-		log.Printf("%+v", err)
+		logutil.Error(ctx, err)
 		return err
 	}
 	return nil
 }
 
-func (p *Service) GetUser(userId string) (model.User, error) {
-	user, err := p.store.GetUser(userId)
+func (p *Service) GetUser(ctx context.Context, userId string) (model.User, error) {
+	ctx = logutil.WithUserId(ctx, userId)
+	user, err := p.store.GetUser(ctx, userId)
 	if err != nil {
-		log.Printf("%+v", err)
+		logutil.Error(ctx, err)
 		return model.User{}, err
 	}
+	if user.UserId == "" {
+		return model.User{}, errors.Error{Code: errors.NotFound, Message: "User not found"}
+	}
 	return user, nil
 }
 
-func (p *Service) CastVote(sessionId string, userId string, estimate string) (model.PendingVote, error) {
-	log.Printf("Voting for session ID [%s] and user ID [%s]", sessionId, userId)
+// GetSession is the state of the session, with the estimates once they have been revealed
+func (p *Service) GetSession(ctx context.Context, sessionId string) (model.SessionSnapshot, error) {
+	ctx = logutil.WithSessionId(ctx, sessionId)
+	session, err := p.store.GetSession(ctx, sessionId)
+	if db.IsNotFound(err) {
+		return model.SessionSnapshot{}, errors.Error{Code: errors.NotFound, Message: "Session not found"}
+	}
+	if err != nil {
+		logutil.Error(ctx, err)
+		return model.SessionSnapshot{}, err
+	}
+	return session, nil
+}
 
-	// cannot vote on session that is inactive
-	sessionKey := fmt.Sprintf(db.Const.SessionState, sessionId)
-	sessionState, err := p.store.GetInt(sessionKey)
+// ExportSession is the record of the session, with every round finished so far
+func (p *Service) ExportSession(ctx context.Context, sessionId string) (model.SessionExport, error) {
+	ctx = logutil.WithSessionId(ctx, sessionId)
+	export, err := p.store.GetSessionExport(ctx, sessionId)
+	if db.IsNotFound(err) {
+		return model.SessionExport{}, errors.Error{Code: errors.NotFound, Message: "Session not found"}
+	}
+	if err != nil {
+		logutil.Error(ctx, err)
+		return model.SessionExport{}, err
+	}
+	return export, nil
+}
 
-	if sessionState == model.NotVoting {
-		return model.PendingVote{},
-			fmt.Errorf("not voting yet for session [%s]", sessionId)
+// newRound is the round just finished, under the session title at the time
+func newRound(users []model.User, tally string, startedAt string, title string, story *model.Story) model.Round {
+	round := model.Round{
+		Title:      title,
+		FinishedAt: time.Now().UTC(),
+		Tally:      tally,
+		Votes:      make([]model.Vote, 0, len(users)),
+		Story:      story,
+	}
+	// a round started before rounds were kept has no start time
+	var err error
+	round.StartedAt, err = time.Parse(time.RFC3339Nano, startedAt)
+	if err != nil {
+		round.StartedAt = round.FinishedAt
 	}
-	log.Printf("Voting for user ID [%s] with estimate [%s]", userId, estimate)
 
-	userKey := fmt.Sprintf(db.Const.User, userId)
+	for _, user := range users {
+		round.Votes = append(round.Votes, model.Vote{UserId: user.UserId, Name: user.Name, Estimate: user.Estimate})
+	}
+	return round
+}
+
+// storyTitle is what a story is called in the chat channel
+func storyTitle(story model.Story) string {
+	if story.Key == "" {
+		return story.Title
+	}
+	return story.Key + " " + story.Title
+}
+
+// QueueStories adds stories to the end of the backlog, and answers with the whole backlog
+func (p *Service) QueueStories(ctx context.Context, sessionId string, stories []model.Story) ([]model.Story, error) {
+	ctx = logutil.WithSessionId(ctx, sessionId)
+	_, err := p.sessionState(ctx, sessionId)
+	if err != nil {
+		return nil, err
+	}
+
+	batch, err := p.store.NewBatch().QueueStories(sessionId, stories)
+	if err == nil {
+		err = p.store.Exec(ctx, batch.Touch(sessionId))
+	}
+	if err != nil {
+		logutil.Error(ctx, err)
+		return nil, err
+	}
+	slog.InfoContext(ctx, "Stories queued", "stories", len(stories))
+
+	return p.GetBacklog(ctx, sessionId)
+}
+
+// GetBacklog is the stories still to be estimated, in order
+func (p *Service) GetBacklog(ctx context.Context, sessionId string) ([]model.Story, error) {
+	ctx = logutil.WithSessionId(ctx, sessionId)
+	_, err := p.sessionState(ctx, sessionId)
+	if err != nil {
+		return nil, err
+	}
+
+	stories, err := p.store.GetBacklog(ctx, sessionId)
+	if err != nil {
+		logutil.Error(ctx, err)
+		return nil, err
+	}
+	return stories, nil
+}
+
+// SetTracker connects the session to an issue tracker, and answers with the settings, without the token
+func (p *Service) SetTracker(ctx context.Context, sessionId string, userId string, settings model.Tracker) (model.Tracker, error) {
+	ctx = logutil.WithUserId(logutil.WithSessionId(ctx, sessionId), userId)
+	_, err := p.sessionState(ctx, sessionId)
+	if err != nil {
+		return model.Tracker{}, err
+	}
+
+	err = p.checkFacilitator(ctx, sessionId, userId, "Only a facilitator of the session can connect it to an issue tracker")
+	if err != nil {
+		return model.Tracker{}, err
+	}
+
+	settings, err = tracker.Validate(settings)
+	if err != nil {
+		return model.Tracker{}, err
+	}
+
+	batch, err := p.store.NewBatch().SetTracker(sessionId, settings)
+	if err == nil {
+		err = p.store.Exec(ctx, batch.Touch(sessionId))
+	}
+	if err != nil {
+		logutil.Error(ctx, err)
+		return model.Tracker{}, err
+	}
+	slog.InfoContext(ctx, "Issue tracker connected", "tracker", settings.Kind, "repo", settings.Repo)
+
+	settings.Token = ""
+	return settings, nil
+}
+
+// sessionTracker is the issue tracker the session is connected to, nil if there is none
+func (p *Service) sessionTracker(ctx context.Context, sessionId string) (tracker.Tracker, error) {
+	settings, err := p.store.GetTracker(ctx, sessionId)
+	if err != nil || settings == nil {
+		return nil, err
+	}
+	return tracker.New(*settings, p.config.GithubApiUrl)
+}
+
+// ImportIssues queues the open issues the query matches, leaving out the ones already in the backlog,
+// and answers with the whole backlog
+func (p *Service) ImportIssues(ctx context.Context, sessionId string, userId string, query string) ([]model.Story, error) {
+	ctx = logutil.WithUserId(logutil.WithSessionId(ctx, sessionId), userId)
+	_, err := p.sessionState(ctx, sessionId)
+	if err != nil {
+		return nil, err
+	}
+
+	err = p.checkFacilitator(ctx, sessionId, userId, "Only a facilitator of the session can import issues")
+	if err != nil {
+		return nil, err
+	}
+
+	issues, err := p.sessionTracker(ctx, sessionId)
+	if err != nil {
+		logutil.Error(ctx, err)
+		return nil, err
+	}
+	if issues == nil {
+		return nil, errors.Error{Code: errors.Conflict, Message: "The session is not connected to an issue tracker"}
+	}
+
+	stories, err := issues.Issues(ctx, query, backlog.MaxStories)
+	if err != nil {
+		slog.WarnContext(ctx, "Could not get issues from the issue tracker", logutil.Err(err))
+		return nil, err
+	}
+
+	queued, err := p.store.GetBacklog(ctx, sessionId)
+	if err != nil {
+		logutil.Error(ctx, err)
+		return nil, err
+	}
+	inBacklog := make(map[string]bool, len(queued))
+	for _, story := range queued {
+		inBacklog[story.Key] = true
+	}
+	newStories := make([]model.Story, 0, len(stories))
+	for _, story := range stories {
+		if !inBacklog[story.Key] {
+			newStories = append(newStories, story)
+		}
+	}
+
+	if len(newStories) == 0 {
+		return nil, errors.Error{Code: errors.Validation, Field: "query",
+			Message: "No open issues match the query, other than the ones in the backlog"}
+	}
+	return p.QueueStories(ctx, sessionId, newStories)
+}
 
-	previousEstimate, err := p.store.GetHashKey(userKey, "estimate")
+// how long writing an estimate back may take, all calls to the tracker together
+const trackerTimeout = 30 * time.Second
+
+// syncEstimate writes an estimate to the issue of the story, if the session is connected to an issue tracker.
+// A tally that is a range, or a "?", is not an estimate, and waits for a facilitator to accept one.
+func (p *Service) syncEstimate(ctx context.Context, sessionId string, story *model.Story, estimate string) {
+	if story == nil || story.Key == "" || !estimatePattern.MatchString(estimate) {
+		return
+	}
+
+	issues, err := p.sessionTracker(ctx, sessionId)
+	if err != nil {
+		logutil.Error(ctx, err)
+		return
+	}
+	if issues == nil {
+		return
+	}
+
+	// the vote is finished without waiting on the tracker, and the tracker is not cut off when the request is over
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		ctx, cancel := context.WithTimeout(ctx, trackerTimeout)
+		defer cancel()
+
+		err := issues.SetEstimate(ctx, *story, estimate)
+		if err != nil {
+			estimatesSynced.WithLabelValues("failed").Inc()
+			slog.WarnContext(ctx, "Could not write the estimate to the issue tracker", "key", story.Key, logutil.Err(err))
+			return
+		}
+		estimatesSynced.WithLabelValues("ok").Inc()
+		slog.InfoContext(ctx, "Estimate written to the issue tracker", "key", story.Key, "estimate", estimate)
+	}()
+}
+
+// Sweep warns the sessions that are about to expire, and announces and purges the ones that have
+func (p *Service) Sweep(ctx context.Context, now time.Time) error {
+	sessions, err := p.store.ExpiringSessions(ctx, now.Add(p.config.SessionExpiryWarning))
+	if err != nil {
+		return err
+	}
+
+	for sessionId, expiresAt := range sessions {
+		ctx := logutil.WithSessionId(ctx, sessionId)
+		if expiresAt.After(now) {
+			err = p.warnExpiring(ctx, sessionId, expiresAt)
+		} else {
+			err = p.expire(ctx, sessionId)
+		}
+		if err != nil {
+			logutil.Error(ctx, err)
+		}
+	}
+	return nil
+}
+
+func (p *Service) warnExpiring(ctx context.Context, sessionId string, expiresAt time.Time) error {
+	claimed, err := p.store.ClaimExpiryWarning(ctx, sessionId, expiresAt)
+	if err != nil || !claimed {
+		return err
+	}
+
+	slog.InfoContext(ctx, "Session expiring", "expires_at", expiresAt)
+	expiring := response.WsSessionExpiring{
+		Event:     response.SessionExpiringEvent,
+		SessionId: sessionId,
+		ExpiresAt: expiresAt.UTC(),
+	}
+	data, err := json.Marshal(expiring)
+	if err != nil {
+		return errorx.EnsureStackTrace(err)
+	}
+	return p.hub.Emit(ctx, sessionId, string(data))
+}
+
+func (p *Service) expire(ctx context.Context, sessionId string) error {
+	claimed, err := p.store.ClaimExpiry(ctx, sessionId)
+	if err != nil || !claimed {
+		return err
+	}
+
+	slog.InfoContext(ctx, "Session expired")
+	expired := response.WsSessionClosed{
+		Event:     response.SessionExpiredEvent,
+		SessionId: sessionId,
+	}
+	data, err := json.Marshal(expired)
+	if err != nil {
+		return errorx.EnsureStackTrace(err)
+	}
+
+	// the hubs disconnect the sockets when they get the event
+	err = p.hub.Emit(ctx, sessionId, string(data))
+	if err != nil {
+		return err
+	}
+	sessionsExpired.Inc()
+
+	// Redis has most likely expired the keys already, but not necessarily all of them at once
+	return p.store.DeleteSession(ctx, sessionId)
+}
+
+// sweep runs Sweep until the service is released
+func (p *Service) sweep() {
+	ticker := time.NewTicker(p.config.SessionSweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.done:
+			return
+		case now := <-ticker.C:
+			err := p.Sweep(context.Background(), now)
+			if err != nil {
+				slog.Warn("Could not sweep expiring sessions", logutil.Err(err))
+			}
+		}
+	}
+}
+
+// sessionState is voting or not, and a not found error once the session has expired
+func (p *Service) sessionState(ctx context.Context, sessionId string) (int, error) {
+	state, err := p.store.GetInt(ctx, fmt.Sprintf(db.Const.SessionState, sessionId))
+	if db.IsNotFound(err) {
+		return 0, errors.Error{Code: errors.NotFound, Field: "session_id", Message: "Session not found"}
+	}
+	if err != nil {
+		logutil.Error(ctx, err)
+		return 0, err
+	}
+	return state, nil
+}
+
+func (p *Service) CastVote(ctx context.Context, sessionId string, userId string, estimate string) (model.PendingVote, error) {
+	ctx = logutil.WithUserId(logutil.WithSessionId(ctx, sessionId), userId)
+	sessionState, err := p.sessionState(ctx, sessionId)
 	if err != nil {
-		log.Printf("%+v", err)
 		return model.PendingVote{}, err
 	}
 
-	err = p.store.SetHashKey(userKey, "estimate", estimate)
+	// cannot vote on session that is inactive
+	if sessionState == model.NotVoting {
+		return model.PendingVote{},
+			errors.Error{Code: errors.Conflict, Message: "Voting has not started in this session"}
+	}
+	slog.DebugContext(ctx, "Casting vote", "estimate", estimate)
+
+	user, err := p.GetUser(ctx, userId)
 	if err != nil {
-		log.Printf("%+v", err)
 		return model.PendingVote{}, err
 	}
+	if user.IsObserver {
+		return model.PendingVote{}, errors.Error{Code: errors.Forbidden, Message: "Observers cannot vote"}
+	}
+	previousEstimate := user.Estimate
+
+	userKey := fmt.Sprintf(db.Const.User, userId)
+
+	batch := p.store.NewBatch().SetHashKey(userKey, "estimate", estimate)
 
 	// increment vote count IF this is a brand new vote for the user this session
 	if previousEstimate == model.NoEstimate {
-		voteCountKey := fmt.Sprintf(db.Const.VoteCount, sessionId)
-		err = p.store.Incr(voteCountKey, 1)
-		if err != nil {
-			log.Printf("%+v", err)
-			return model.PendingVote{}, err
-		}
+		batch.Incr(fmt.Sprintf(db.Const.VoteCount, sessionId), 1)
+	}
+
+	err = p.store.Exec(ctx, batch.Touch(sessionId))
+	if err != nil {
+		logutil.Error(ctx, err)
+		return model.PendingVote{}, err
 	}
+	votesCast.Inc()
 
 	wsUserVote := response.WsUserVote{
 		Event:  response.UserVotedEVent,
@@ -263,21 +788,21 @@ func (p *Service) CastVote(sessionId string, userId string, estimate string) (mo
 
 	data, err := json.Marshal(wsUserVote)
 	if err != nil {
-		log.Printf("%+v", errorx.EnsureStackTrace(err))
+		logutil.Error(ctx, errorx.EnsureStackTrace(err))
 		return model.PendingVote{}, errorx.EnsureStackTrace(err)
 	}
 
-	err = p.hub.Emit(sessionId, string(data))
+	err = p.hub.Emit(ctx, sessionId, string(data))
 	if err != nil {
-		log.Printf("%+v", errorx.EnsureStackTrace(err))
+		logutil.Error(ctx, errorx.EnsureStackTrace(err))
 		return model.PendingVote{}, errorx.EnsureStackTrace(err)
 	}
 
-	voteFinished, err := p.IsVoteFinished(sessionId)
+	voteFinished, err := p.IsVoteFinished(ctx, sessionId)
 	if voteFinished == true {
-		err = p.FinishVote(sessionId)
+		err = p.FinishVote(ctx, sessionId)
 		if err != nil {
-			log.Printf("%+v", err)
+			logutil.Error(ctx, err)
 			return model.PendingVote{}, err
 		}
 	}
@@ -290,37 +815,52 @@ func (p *Service) CastVote(sessionId string, userId string, estimate string) (mo
 	return vote, nil
 }
 
-func (p *Service) StartVote(sessionId string) error {
-	log.Printf("Starting vote for session ID [%s]", sessionId)
-	key := fmt.Sprintf(db.Const.SessionState, sessionId)
-	err := p.store.Set(key, model.Voting)
+func (p *Service) StartVote(ctx context.Context, sessionId string) error {
+	ctx = logutil.WithSessionId(ctx, sessionId)
+	slog.InfoContext(ctx, "Starting vote")
+
+	_, err := p.sessionState(ctx, sessionId)
 	if err != nil {
-		log.Printf("%+v", err)
 		return err
 	}
 
-	key = fmt.Sprintf(db.Const.VoteCount, sessionId)
-	err = p.store.Set(key, 0)
+	userIds, err := p.store.GetSessionVoterIds(ctx, sessionId)
 	if err != nil {
-		log.Printf("%+v", err)
+		logutil.Error(ctx, err)
 		return err
 	}
 
-	key = fmt.Sprintf(db.Const.Tally, sessionId)
-	err = p.store.Set(key, "")
+	batch := p.store.NewBatch()
+	batch.Set(fmt.Sprintf(db.Const.SessionState, sessionId), model.Voting)
+	batch.Set(fmt.Sprintf(db.Const.VoteCount, sessionId), 0)
+	batch.Set(fmt.Sprintf(db.Const.Tally, sessionId), "")
+	batch.Set(fmt.Sprintf(db.Const.Estimate, sessionId), "")
+	batch.Set(fmt.Sprintf(db.Const.RoundStarted, sessionId), time.Now().UTC().Format(time.RFC3339Nano))
+
+	// the next story of the backlog, if there is one, is what is estimated
+	next, err := p.store.NextStory(ctx, sessionId)
 	if err != nil {
-		log.Printf("%+v", err)
+		logutil.Error(ctx, err)
 		return err
 	}
+	if next != nil {
+		batch.Set(fmt.Sprintf(db.Const.Story, sessionId), next)
+	} else {
+		// the backlog has run out, and its last story no longer applies
+		batch.Del(fmt.Sprintf(db.Const.Story, sessionId))
+	}
 
 	// reset user state
-	userIds, err := p.store.GetSessionVoterIds(sessionId)
+	for _, userId := range userIds {
+		batch.SetHashKey(fmt.Sprintf(db.Const.User, userId), "estimate", model.NoEstimate)
+	}
 
-	for i := 0; i < len(userIds); i++ {
-		userId := userIds[i]
-		userKey := fmt.Sprintf(db.Const.User, userId)
-		err = p.store.SetHashKey(userKey, "estimate", model.NoEstimate)
+	err = p.store.Exec(ctx, batch.Touch(sessionId))
+	if err != nil {
+		logutil.Error(ctx, err)
+		return err
 	}
+	roundsStarted.Inc()
 
 	session := response.WsVoteStarted{
 		Event: response.VoteStartedEVent,
@@ -328,30 +868,180 @@ func (p *Service) StartVote(sessionId string) error {
 
 	data, err := json.Marshal(session)
 	if err != nil {
-		log.Printf("%+v", errorx.EnsureStackTrace(err))
+		logutil.Error(ctx, errorx.EnsureStackTrace(err))
 		return errorx.EnsureStackTrace(err)
 	}
 
-	err = p.hub.Emit(sessionId, string(data))
+	err = p.hub.Emit(ctx, sessionId, string(data))
 	if err != nil {
-		log.Printf("%+v", errorx.EnsureStackTrace(err))
+		logutil.Error(ctx, errorx.EnsureStackTrace(err))
 		return errorx.EnsureStackTrace(err)
 	}
 
 	return nil
 }
 
-func (p *Service) FinishVote(sessionId string) error {
-	key := fmt.Sprintf(db.Const.SessionState, sessionId)
-	err := p.store.Set(key, model.NotVoting)
+// ReopenVote takes back a finished vote, as one finished by mistake, or by a last voter who misclicked. The
+// estimates cast are kept, and hidden again, and the round is recorded again when the vote finishes again.
+func (p *Service) ReopenVote(ctx context.Context, sessionId string, userId string) error {
+	ctx = logutil.WithUserId(logutil.WithSessionId(ctx, sessionId), userId)
+	sessionState, err := p.sessionState(ctx, sessionId)
 	if err != nil {
-		log.Printf("%+v", err)
 		return err
 	}
 
-	users, err := p.store.GetSessionVoters(sessionId)
+	err = p.checkFacilitator(ctx, sessionId, userId, "Only a facilitator of the session can reopen a vote")
 	if err != nil {
-		log.Printf("%+v", err)
+		return err
+	}
+	if sessionState == model.Voting {
+		return errors.Error{Code: errors.Conflict, Message: "The vote is still open"}
+	}
+
+	// there is no tally until the first vote finishes
+	vals, err := p.store.GetStrs(ctx, fmt.Sprintf(db.Const.Tally, sessionId))
+	if err != nil {
+		logutil.Error(ctx, err)
+		return err
+	}
+	tally := vals[0]
+	if tally == "" {
+		return errors.Error{Code: errors.Conflict, Message: "No vote has finished in this session yet"}
+	}
+
+	// checked again as the vote is reopened, in case the session moved on since
+	reopened, err := p.store.ReopenVote(ctx, sessionId)
+	if err != nil {
+		logutil.Error(ctx, err)
+		return err
+	}
+	if !reopened {
+		return errors.Error{Code: errors.Conflict, Message: "The vote is still open"}
+	}
+	roundsReopened.Inc()
+	slog.InfoContext(ctx, "Vote reopened", "tally", tally)
+
+	users, err := p.store.GetSessionVoters(ctx, sessionId)
+	if err != nil {
+		logutil.Error(ctx, err)
+		return err
+	}
+	for idx := range users {
+		users[idx].Estimate = model.NoEstimate
+	}
+
+	started := response.WsVoteStarted{
+		Event:    response.VoteStartedEVent,
+		Reopened: true,
+		Users:    users,
+	}
+	data, err := json.Marshal(started)
+	if err != nil {
+		logutil.Error(ctx, errorx.EnsureStackTrace(err))
+		return errorx.EnsureStackTrace(err)
+	}
+
+	err = p.hub.Emit(ctx, sessionId, string(data))
+	if err != nil {
+		logutil.Error(ctx, errorx.EnsureStackTrace(err))
+		return errorx.EnsureStackTrace(err)
+	}
+
+	return nil
+}
+
+// the longest estimate a facilitator can accept
+const maxEstimateLength = 16
+
+// an estimate is a whole number, or a fraction such as 0.5
+var estimatePattern = regexp.MustCompile(`^[0-9]+(\.[0-9]+)?$`)
+
+// AcceptEstimate settles the estimate of the last round, which may differ from its tally. The tally is kept
+// as it was computed.
+func (p *Service) AcceptEstimate(ctx context.Context, sessionId string, userId string, estimate string) (model.Round, error) {
+	ctx = logutil.WithUserId(logutil.WithSessionId(ctx, sessionId), userId)
+	sessionState, err := p.sessionState(ctx, sessionId)
+	if err != nil {
+		return model.Round{}, err
+	}
+
+	err = p.checkFacilitator(ctx, sessionId, userId, "Only a facilitator of the session can accept an estimate")
+	if err != nil {
+		return model.Round{}, err
+	}
+
+	estimate = strings.TrimSpace(estimate)
+	switch {
+	case estimate == "":
+		return model.Round{}, errors.Error{Code: errors.Validation, Field: "estimate", Message: "This field cannot be empty"}
+	case !estimatePattern.MatchString(estimate) || len(estimate) > maxEstimateLength:
+		return model.Round{}, errors.Error{Code: errors.Validation, Field: "estimate", Message: "The estimate must be a number"}
+	}
+
+	if sessionState == model.Voting {
+		return model.Round{}, errors.Error{Code: errors.Conflict, Message: "An estimate can only be accepted once the vote is finished"}
+	}
+	round, err := p.store.LastRound(ctx, sessionId)
+	if err != nil {
+		logutil.Error(ctx, err)
+		return model.Round{}, err
+	}
+	if round == nil {
+		return model.Round{}, errors.Error{Code: errors.Conflict, Message: "No vote has finished in this session yet"}
+	}
+
+	round.Estimate = estimate
+	accepted, err := p.store.AcceptEstimate(ctx, sessionId, *round)
+	if err != nil {
+		logutil.Error(ctx, err)
+		return model.Round{}, err
+	}
+	// a vote started, or started and finished, since the round was read
+	if !accepted {
+		return model.Round{}, errors.Error{Code: errors.Conflict, Message: "The vote has moved on since - accept the estimate again"}
+	}
+	estimatesAccepted.Inc()
+	slog.InfoContext(ctx, "Estimate accepted", "round", round.Number, "tally", round.Tally, "estimate", estimate)
+
+	event := response.WsEstimateAccepted{
+		Event:    response.EstimateAcceptedEvent,
+		Round:    round.Number,
+		Tally:    round.Tally,
+		Estimate: estimate,
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		logutil.Error(ctx, errorx.EnsureStackTrace(err))
+		return model.Round{}, errorx.EnsureStackTrace(err)
+	}
+
+	err = p.hub.Emit(ctx, sessionId, string(data))
+	if err != nil {
+		logutil.Error(ctx, errorx.EnsureStackTrace(err))
+		return model.Round{}, errorx.EnsureStackTrace(err)
+	}
+
+	p.syncEstimate(ctx, sessionId, round.Story, estimate)
+
+	return *round, nil
+}
+
+func (p *Service) FinishVote(ctx context.Context, sessionId string) error {
+	ctx = logutil.WithSessionId(ctx, sessionId)
+	_, err := p.sessionState(ctx, sessionId)
+	if err != nil {
+		return err
+	}
+
+	wasVoting, err := p.store.EndVoting(ctx, sessionId)
+	if err != nil {
+		logutil.Error(ctx, err)
+		return err
+	}
+
+	users, err := p.store.GetSessionVoters(ctx, sessionId)
+	if err != nil {
+		logutil.Error(ctx, err)
 		return err
 	}
 
@@ -366,12 +1056,53 @@ func (p *Service) FinishVote(sessionId string) error {
 		return err
 	}
 
-	key = fmt.Sprintf(db.Const.Tally, sessionId)
-	err = p.store.Set(key, tally)
+	// what the round and the chat channel need, read at once
+	vals, err := p.store.GetStrs(ctx,
+		fmt.Sprintf(db.Const.RoundStarted, sessionId),
+		fmt.Sprintf(db.Const.Title, sessionId),
+		fmt.Sprintf(db.Const.Story, sessionId),
+		fmt.Sprintf(db.Const.ResponseUrl, sessionId))
 	if err != nil {
-		log.Printf("%+v", err)
+		logutil.Error(ctx, err)
 		return err
 	}
+	title, stored, responseUrl := vals[1], vals[2], vals[3]
+
+	var story *model.Story
+	if stored != "" {
+		story = &model.Story{}
+		err = json.Unmarshal([]byte(stored), story)
+		if err != nil {
+			logutil.Error(ctx, errorx.EnsureStackTrace(err))
+			return errorx.EnsureStackTrace(err)
+		}
+	}
+
+	// the tally, and the round for the one caller that ended the vote, go in one transaction
+	batch := p.store.NewBatch().Set(fmt.Sprintf(db.Const.Tally, sessionId), tally)
+	var round model.Round
+	if wasVoting {
+		round = newRound(users, tally, vals[0], title, story)
+		batch, err = batch.AddRound(sessionId, round)
+		if err != nil {
+			logutil.Error(ctx, err)
+			return err
+		}
+		if story != nil {
+			batch.EstimatedStory(sessionId, []byte(stored))
+		}
+	}
+	err = p.store.Exec(ctx, batch.Touch(sessionId))
+	if err != nil {
+		logutil.Error(ctx, err)
+		return err
+	}
+
+	if wasVoting {
+		p.syncEstimate(ctx, sessionId, round.Story, round.Tally)
+		roundsFinished.Inc()
+	}
+	slog.InfoContext(ctx, "Vote finished", "tally", tally)
 
 	session := response.WsVoteFinished{
 		Event: response.VoteFinishedEvent,
@@ -381,30 +1112,59 @@ func (p *Service) FinishVote(sessionId string) error {
 
 	data, err := json.Marshal(session)
 	if err != nil {
-		log.Printf("%+v", errorx.EnsureStackTrace(err))
+		logutil.Error(ctx, errorx.EnsureStackTrace(err))
 		return errorx.EnsureStackTrace(err)
 	}
 
-	err = p.hub.Emit(sessionId, string(data))
+	err = p.hub.Emit(ctx, sessionId, string(data))
 	if err != nil {
-		log.Printf("%+v", errorx.EnsureStackTrace(err))
+		logutil.Error(ctx, errorx.EnsureStackTrace(err))
 		return errorx.EnsureStackTrace(err)
 	}
 
+	// posted once, by the caller that ended the vote
+	if wasVoting {
+		// the story of the round, if it had one, is what was estimated
+		if story != nil {
+			title = storyTitle(*story)
+		}
+		p.postTally(ctx, responseUrl, title, tally)
+	}
+
 	return nil
 }
 
-func (p *Service) IsVoteFinished(sessionId string) (bool, error) {
+// postTally sends the vote result back to the chat channel the session was started from, if any
+func (p *Service) postTally(ctx context.Context, responseUrl string, title string, tally string) {
+	if responseUrl == "" {
+		return
+	}
+
+	text := fmt.Sprintf("Vote finished. Tally: *%s*", tally)
+	if title != "" {
+		text = fmt.Sprintf("Vote finished for *%s*. Tally: *%s*", title, tally)
+	}
+
+	go func() {
+		err := slash.Post(responseUrl, slash.Message{ResponseType: slash.InChannel, Text: text})
+		if err != nil {
+			slog.WarnContext(ctx, "Could not post the tally", logutil.Err(err))
+		}
+	}()
+}
+
+func (p *Service) IsVoteFinished(ctx context.Context, sessionId string) (bool, error) {
+	ctx = logutil.WithSessionId(ctx, sessionId)
 	voteCountKey := fmt.Sprintf(db.Const.VoteCount, sessionId)
-	voteCount, err := p.store.GetInt(voteCountKey)
+	voteCount, err := p.store.GetInt(ctx, voteCountKey)
 	if err != nil {
-		log.Printf("%+v", err)
+		logutil.Error(ctx, err)
 		return false, err
 	}
 
-	userCount, err := p.store.GetSetLength(fmt.Sprintf(db.Const.SessionUsers, sessionId))
+	userCount, err := p.store.GetSetLength(ctx, fmt.Sprintf(db.Const.SessionUsers, sessionId))
 	if err != nil {
-		log.Printf("%+v", err)
+		logutil.Error(ctx, err)
 		return false, err
 	}
 
@@ -412,40 +1172,43 @@ func (p *Service) IsVoteFinished(sessionId string) (bool, error) {
 }
 
 func (p *Service) processSubscriberEvent(sessionId string, data string) {
+	// not tied to any request - the store timeout still applies
+	ctx := logutil.WithSessionId(context.Background(), sessionId)
+
 	jsonData, err := jsonutil.GetJsonFromString(data)
 	if err != nil {
-		log.Printf("%+v", err)
+		logutil.Error(ctx, err)
 	}
 
 	event, ok := jsonData["event"].(string)
 	if !ok {
-		log.Printf("no event found in: %v", data)
+		slog.WarnContext(ctx, "No event found")
 		return
 	}
 
 	if event == Event.UserLeft {
 		userId, ok := jsonData["user_id"].(string)
 		if !ok {
-			log.Printf("no user_id found in: %v", data)
+			slog.WarnContext(ctx, "No user_id found", "event", event)
 			return
 		}
 
-		err = p.RemoveUserFromSession(sessionId, userId)
+		err = p.RemoveUserFromSession(ctx, sessionId, userId)
 		if err != nil {
-			log.Printf("Error removnig user: %+v", err)
+			logutil.Error(ctx, err)
 		}
 	}
 
 	if event == Event.ObserverLeft {
 		userId, ok := jsonData["user_id"].(string)
 		if !ok {
-			log.Printf("no user_id found in: %v", data)
+			slog.WarnContext(ctx, "No user_id found", "event", event)
 			return
 		}
 
-		err = p.RemoveObserver(sessionId, userId)
+		err = p.RemoveObserver(ctx, sessionId, userId)
 		if err != nil {
-			log.Printf("Error removing observer: %+v", err)
+			logutil.Error(ctx, err)
 		}
 	}
 }
@@ -467,8 +1230,7 @@ func (p *Service) GetVoteResult(sInputs []string) (string, error) {
 
 		iInput, err := strconv.Atoi(sInput)
 		if err != nil {
-			log.Printf("%+v", errorx.EnsureStackTrace(err))
-			return "", err
+			return "", errorx.EnsureStackTrace(err)
 		}
 		inputs = append(inputs, iInput)
 	}
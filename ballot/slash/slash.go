@@ -0,0 +1,156 @@
+package slash
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/joomcode/errorx"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/* Slash commands in the Slack/Mattermost format.
+https://api.slack.com/interactivity/slash-commands
+https://developers.mattermost.com/integrate/slash-commands/custom/
+*/
+
+const (
+	SignatureHeader = "X-Slack-Signature"
+	TimestampHeader = "X-Slack-Request-Timestamp"
+
+	signatureVersion = "v0"
+	// Slack recommends rejecting requests older than five minutes to prevent replay attacks
+	maxRequestAge = 5 * time.Minute
+)
+
+var Action = struct {
+	New  string
+	Help string
+}{
+	"new",
+	"help",
+}
+
+const (
+	InChannel = "in_channel"
+	Ephemeral = "ephemeral"
+)
+
+type Command struct {
+	Token       string
+	Command     string
+	Text        string
+	UserName    string
+	ResponseUrl string
+}
+
+type Message struct {
+	ResponseType string `json:"response_type"`
+	Text         string `json:"text"`
+}
+
+var httpClient = &http.Client{Timeout: 10 * time.Second}
+
+func ParseCommand(form url.Values) Command {
+	return Command{
+		Token:       form.Get("token"),
+		Command:     form.Get("command"),
+		Text:        strings.TrimSpace(form.Get("text")),
+		UserName:    form.Get("user_name"),
+		ResponseUrl: form.Get("response_url"),
+	}
+}
+
+// Verify checks the request against the Slack signing secret, if configured, and falls back
+// to the Mattermost verification token. With neither configured, every request is rejected.
+func Verify(signingSecret string, token string, header http.Header, body []byte, now time.Time) error {
+	if signingSecret != "" && header.Get(SignatureHeader) != "" {
+		return verifySignature(signingSecret, header, body, now)
+	}
+
+	if token != "" {
+		form, err := url.ParseQuery(string(body))
+		if err != nil {
+			return errorx.EnsureStackTrace(err)
+		}
+
+		if subtle.ConstantTimeCompare([]byte(form.Get("token")), []byte(token)) == 1 {
+			return nil
+		}
+		return fmt.Errorf("invalid slash command token")
+	}
+
+	return fmt.Errorf("slash command is not signed")
+}
+
+func verifySignature(signingSecret string, header http.Header, body []byte, now time.Time) error {
+	timestamp, err := strconv.ParseInt(header.Get(TimestampHeader), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid slash command timestamp")
+	}
+
+	age := now.Sub(time.Unix(timestamp, 0))
+	if age > maxRequestAge || age < -maxRequestAge {
+		return fmt.Errorf("stale slash command timestamp")
+	}
+
+	expected := Sign(signingSecret, header.Get(TimestampHeader), body)
+	if !hmac.Equal([]byte(header.Get(SignatureHeader)), []byte(expected)) {
+		return fmt.Errorf("invalid slash command signature")
+	}
+
+	return nil
+}
+
+// Sign computes the value of the signature header for a request body sent at the given timestamp
+func Sign(signingSecret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(signingSecret))
+	mac.Write([]byte(signatureVersion + ":" + timestamp + ":"))
+	mac.Write(body)
+	return signatureVersion + "=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// SplitText separates the action from its argument: `new "Story title"` -> "new", "Story title"
+func SplitText(text string) (string, string) {
+	text = strings.TrimSpace(text)
+	action, arg, _ := strings.Cut(text, " ")
+	arg = strings.TrimSpace(arg)
+
+	// chat clients like to turn straight quotes into smart quotes
+	arg = strings.Trim(arg, "\"'“”‘’")
+
+	return strings.ToLower(action), strings.TrimSpace(arg)
+}
+
+func Post(responseUrl string, msg Message) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return errorx.EnsureStackTrace(err)
+	}
+
+	resp, err := httpClient.Post(responseUrl, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return errorx.EnsureStackTrace(err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("posting to response URL failed with status %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+func Usage(command string) string {
+	if command == "" {
+		command = "/ballot"
+	}
+	return fmt.Sprintf("Usage: `%s new \"Story title\"` starts a new estimation session", command)
+}
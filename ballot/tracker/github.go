@@ -0,0 +1,175 @@
+package tracker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/joomcode/errorx"
+	"github.com/papito/ballot/ballot/errors"
+	"github.com/papito/ballot/ballot/model"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+/* GitHub Issues, over the REST API. Issues are found with the issue search, and an estimate is a label,
+such as "estimate: 5", that takes the place of any other label the issue has with the same prefix.
+https://docs.github.com/en/rest/search/search#search-issues-and-pull-requests
+https://docs.github.com/en/rest/issues/labels
+*/
+
+// the search qualifiers that would take the search out of the open issues of the repository
+var scopeQualifiers = []string{"repo:", "org:", "user:", "is:"}
+
+const (
+	gitHubPageSize = 100
+	// the search API does not go past the first 1000 results
+	gitHubMaxPages = 10
+)
+
+type gitHub struct {
+	apiUrl string
+	repo   string
+	token  string
+	label  string
+	client *http.Client
+}
+
+type gitHubIssue struct {
+	Number  int    `json:"number"`
+	Title   string `json:"title"`
+	HtmlUrl string `json:"html_url"`
+}
+
+type gitHubLabel struct {
+	Name string `json:"name"`
+}
+
+func (p *gitHub) Issues(ctx context.Context, query string, limit int) ([]model.Story, error) {
+	err := checkQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	q := strings.TrimSpace(fmt.Sprintf("repo:%s is:issue is:open %s", p.repo, query))
+
+	stories := make([]model.Story, 0)
+	for page := 1; page <= gitHubMaxPages && len(stories) < limit; page++ {
+		params := url.Values{
+			"q":        {q},
+			"sort":     {"created"},
+			"order":    {"asc"},
+			"per_page": {strconv.Itoa(gitHubPageSize)},
+			"page":     {strconv.Itoa(page)},
+		}
+		var result struct {
+			Items []gitHubIssue `json:"items"`
+		}
+		err := p.do(ctx, "GET", "/search/issues?"+params.Encode(), nil, &result)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, issue := range result.Items {
+			if len(stories) == limit {
+				break
+			}
+			stories = append(stories, model.Story{
+				Key:   strconv.Itoa(issue.Number),
+				Title: issue.Title,
+				Link:  issue.HtmlUrl,
+			})
+		}
+		if len(result.Items) < gitHubPageSize {
+			break
+		}
+	}
+	return stories, nil
+}
+
+// checkQuery turns down a query that scopes the search itself. The token may see more than the repository,
+// and the titles of the issues found end up in the backlog, for anyone in the session to read.
+func checkQuery(query string) error {
+	for _, term := range strings.Fields(query) {
+		term = strings.ToLower(strings.TrimLeft(term, `-("`))
+		for _, qualifier := range scopeQualifiers {
+			if strings.HasPrefix(term, qualifier) {
+				return errors.Error{Code: errors.Validation, Field: "query",
+					Message: fmt.Sprintf("The query cannot have a %s qualifier", strings.TrimSuffix(qualifier, ":"))}
+			}
+		}
+	}
+	return nil
+}
+
+func (p *gitHub) SetEstimate(ctx context.Context, story model.Story, estimate string) error {
+	number, err := strconv.Atoi(story.Key)
+	if err != nil || number <= 0 {
+		return errors.Error{Code: errors.Validation, Field: "key", Message: "The story is not a GitHub issue"}
+	}
+	path := fmt.Sprintf("/repos/%s/issues/%d/labels", p.repo, number)
+	label := p.label + estimate
+
+	var labels []gitHubLabel
+	err = p.do(ctx, "GET", path+"?per_page=100", nil, &labels)
+	if err != nil {
+		return err
+	}
+
+	for _, existing := range labels {
+		if existing.Name == label {
+			return nil
+		}
+		if strings.HasPrefix(existing.Name, p.label) {
+			err = p.do(ctx, "DELETE", path+"/"+url.PathEscape(existing.Name), nil, nil)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return p.do(ctx, "POST", path, map[string][]string{"labels": {label}}, nil)
+}
+
+func (p *gitHub) do(ctx context.Context, method string, path string, reqObj interface{}, respObj interface{}) error {
+	var body io.Reader
+	if reqObj != nil {
+		data, err := json.Marshal(reqObj)
+		if err != nil {
+			return errorx.EnsureStackTrace(err)
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, p.apiUrl+path, body)
+	if err != nil {
+		return errorx.EnsureStackTrace(err)
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("Authorization", "Bearer "+p.token)
+	req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return errors.Error{Code: errors.Upstream, Message: "The issue tracker could not be reached"}
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return failed(resp.StatusCode)
+	}
+	if respObj == nil {
+		return nil
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(respObj)
+	if err != nil {
+		return errorx.EnsureStackTrace(err)
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package tracker
+
+import (
+	"context"
+	"fmt"
+	"github.com/papito/ballot/ballot/errors"
+	"github.com/papito/ballot/ballot/model"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+/* An issue tracker a session is connected to. Its issues are queued in the backlog as stories, keyed by
+the issue number, and the tally of a story's round is written back to its issue.
+
+A session is connected with model.Tracker settings. The API the settings talk to is the server's to
+configure, not the session's, so that a session cannot point the server at an address of its choosing.
+*/
+
+const (
+	GitHub = "github"
+
+	// DefaultLabel is put in front of the estimate, to make the label of an estimated issue
+	DefaultLabel = "estimate: "
+)
+
+type Tracker interface {
+	// Issues are the open issues the query matches, as stories, at most limit of them
+	Issues(ctx context.Context, query string, limit int) ([]model.Story, error)
+	// SetEstimate writes the estimate to the issue of the story, in place of the one it had
+	SetEstimate(ctx context.Context, story model.Story, estimate string) error
+}
+
+var httpClient = &http.Client{Timeout: 10 * time.Second}
+
+var repoPattern = regexp.MustCompile(`^[A-Za-z0-9-]+/[A-Za-z0-9._-]+$`)
+
+// Validate checks the settings a session is connected with, and fills in the defaults
+func Validate(settings model.Tracker) (model.Tracker, error) {
+	settings.Kind = strings.ToLower(strings.TrimSpace(settings.Kind))
+	settings.Repo = strings.TrimSpace(settings.Repo)
+	settings.Token = strings.TrimSpace(settings.Token)
+	if settings.Label == "" {
+		settings.Label = DefaultLabel
+	}
+
+	switch {
+	case settings.Kind != GitHub:
+		return settings, errors.Error{Code: errors.Validation, Field: "kind", Message: "The tracker must be github"}
+	case !repoPattern.MatchString(settings.Repo):
+		return settings, errors.Error{Code: errors.Validation, Field: "repo", Message: "The repository must be owner/name"}
+	case settings.Token == "":
+		return settings, errors.Error{Code: errors.Validation, Field: "token", Message: "This field cannot be empty"}
+	case len(settings.Label) > 40:
+		return settings, errors.Error{Code: errors.Validation, Field: "label", Message: "This field can be at most 40 characters"}
+	}
+	return settings, nil
+}
+
+// New is the tracker the settings are for. GitHub is reached at apiUrl.
+func New(settings model.Tracker, apiUrl string) (Tracker, error) {
+	switch settings.Kind {
+	case GitHub:
+		return &gitHub{
+			apiUrl: strings.TrimSuffix(apiUrl, "/"),
+			repo:   settings.Repo,
+			token:  settings.Token,
+			label:  settings.Label,
+			client: httpClient,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown tracker %q", settings.Kind)
+	}
+}
+
+// failed is a tracker that answered with an error status, as an API error
+func failed(status int) error {
+	switch status {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return errors.Error{Code: errors.Upstream, Message: "The issue tracker did not accept the token"}
+	case http.StatusNotFound:
+		return errors.Error{Code: errors.Upstream, Message: "The issue tracker could not find the repository or the issue"}
+	case http.StatusUnprocessableEntity:
+		return errors.Error{Code: errors.Validation, Field: "query", Message: "The issue tracker could not make sense of the query"}
+	default:
+		return errors.Error{Code: errors.Upstream, Message: fmt.Sprintf("The issue tracker failed with status %d", status)}
+	}
+}
@@ -0,0 +1,221 @@
+package ui
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/joomcode/errorx"
+	"github.com/papito/ballot/ballot/logutil"
+	"github.com/shurcooL/httpgzip"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+/* The UI build is copied into ui/dist before the server is compiled (see "make ui"), and embedded into the binary.
+The embedded files never change while the server runs, so they are compressed once on startup, and browsers
+may cache anything under assets/ for good, since Vite puts a content hash into those file names.
+*/
+
+//go:embed all:dist
+var dist embed.FS
+
+const indexPath = "index.html"
+
+// Build is the UI build embedded into the binary
+func Build() fs.FS {
+	build, err := fs.Sub(dist, "dist")
+	if err != nil {
+		// only possible with a malformed directory name
+		panic(err)
+	}
+	return build
+}
+
+// asset is a UI file held in memory, with its gzipped bytes when compressing it was worth it
+type asset struct {
+	name string
+	data []byte
+	gzip []byte
+	etag string
+
+	// gzipped on startup - no gzip bytes then means it was not worth it
+	compressed bool
+}
+
+type handler struct {
+	build  fs.FS
+	assets map[string]*asset
+
+	// a build on disk is read on every request, so a rebuild shows up on reload
+	live bool
+}
+
+// NewHandler serves a single-page app build: paths that are not files get index.html.
+// A live build, such as one on disk during development, is read on every request and never cached.
+func NewHandler(build fs.FS, live bool) (http.Handler, error) {
+	h := &handler{build: build, live: live}
+	if live {
+		return h, nil
+	}
+
+	h.assets = make(map[string]*asset)
+	err := fs.WalkDir(build, ".", func(name string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		a, err := h.read(name)
+		if err != nil {
+			return err
+		}
+		a.gzip, err = compress(a.data)
+		if err != nil {
+			return err
+		}
+		a.compressed = true
+		h.assets[name] = a
+		return nil
+	})
+	if err != nil {
+		return nil, errorx.EnsureStackTrace(err)
+	}
+
+	if _, ok := h.assets[indexPath]; !ok {
+		slog.Warn("The UI is not built into this binary")
+	}
+	return h, nil
+}
+
+func (h *handler) read(name string) (*asset, error) {
+	data, err := fs.ReadFile(h.build, name)
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256(data)
+	return &asset{
+		name: name,
+		data: data,
+		// weak, since the gzipped and the plain file are the same as far as caching goes
+		etag: fmt.Sprintf(`W/"%s"`, hex.EncodeToString(sum[:8])),
+	}, nil
+}
+
+// compress gives nothing back for files that do not get any smaller
+func compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	gw, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	_, err = gw.Write(data)
+	if err != nil {
+		return nil, err
+	}
+	err = gw.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	if buf.Len() >= len(data) {
+		return nil, nil
+	}
+	return buf.Bytes(), nil
+}
+
+func (h *handler) asset(name string) (*asset, error) {
+	if !h.live {
+		a, ok := h.assets[name]
+		if !ok {
+			return nil, fs.ErrNotExist
+		}
+		return a, nil
+	}
+
+	info, err := fs.Stat(h.build, name)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return nil, fs.ErrNotExist
+	}
+	return h.read(name)
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+	a, err := h.asset(name)
+	if name == "" || errors.Is(err, fs.ErrNotExist) {
+		// a route of the app
+		a, err = h.asset(indexPath)
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		http.Error(w, "The UI is not built, see \"make ui\"", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		logutil.Error(r.Context(), errorx.EnsureStackTrace(err))
+		http.Error(w, "Could not read the UI", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Cache-Control", h.cacheControl(a.name))
+	w.Header().Set("Vary", "Accept-Encoding")
+	w.Header().Set("ETag", a.etag)
+
+	// without a modification time, conditional requests are answered with the ETag alone
+	httpgzip.ServeContent(w, r, a.name, time.Time{}, a.content())
+}
+
+func (h *handler) cacheControl(name string) string {
+	switch {
+	case h.live || name == indexPath:
+		return "no-cache"
+	case strings.HasPrefix(name, "assets/"):
+		return "public, max-age=31536000, immutable"
+	default:
+		return "public, max-age=3600"
+	}
+}
+
+// content lets httpgzip use the bytes gzipped up front. Live files are left for it to compress.
+func (a *asset) content() io.ReadSeeker {
+	reader := bytes.NewReader(a.data)
+	switch {
+	case !a.compressed:
+		return reader
+	case a.gzip == nil:
+		return notWorthGzipping{reader}
+	default:
+		return gzipped{Reader: reader, gzip: a.gzip}
+	}
+}
+
+type gzipped struct {
+	*bytes.Reader
+	gzip []byte
+}
+
+func (g gzipped) GzipBytes() []byte {
+	return g.gzip
+}
+
+type notWorthGzipping struct {
+	*bytes.Reader
+}
+
+func (notWorthGzipping) NotWorthGzipCompressing() {}